@@ -0,0 +1,259 @@
+package bosh
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/cfhttp"
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+)
+
+// Deployment is the product owning a BOSH deployment
+type Deployment struct {
+	Product string `json:"product"`
+	Version string `json:"version"`
+}
+
+// Instance is a BOSH instance, as listed by the director's /deployments/<name>/instances
+type Instance struct {
+	Deployment    string   `json:"deployment"`
+	InstanceGroup string   `json:"job"`
+	ID            string   `json:"id"`
+	Index         *int     `json:"index"`
+	AZ            string   `json:"az"`
+	IPs           []string `json:"ips"`
+}
+
+// StaticMetadata is the static mapping of deployments and instances, it
+// overrides what the director reports
+type StaticMetadata struct {
+	Deployments map[string]Deployment `json:"deployments"`
+	Instances   []Instance            `json:"instances"`
+}
+
+// Config of the BOSH metadata, the director is optional
+type Config struct {
+	DirectorURL     string
+	Username        string
+	Password        string
+	SkipSSL         bool
+	RefreshInterval time.Duration
+	Static          string // JSON or path of a JSON file holding StaticMetadata
+	Logger          lager.Logger
+}
+
+// Metadata enriches events with the product owning their deployment and
+// the instance group and availability zone of their instance
+type Metadata struct {
+	config     *Config
+	static     StaticMetadata
+	httpClient *http.Client
+
+	mutex       sync.RWMutex
+	deployments map[string]Deployment
+	instances   map[string]Instance // by IP, deployment/id and deployment/job/index
+
+	closing chan struct{}
+	wg      sync.WaitGroup
+}
+
+// Ops Manager suffixes deployment names with a hash of the product
+var productSuffix = regexp.MustCompile(`-[0-9a-f]{20}$`)
+
+// NewMetadata parses the static metadata and prepares the director client
+func NewMetadata(config *Config) (*Metadata, error) {
+	m := &Metadata{
+		config:  config,
+		closing: make(chan struct{}),
+	}
+
+	if strings.TrimSpace(config.Static) != "" {
+		if err := utils.UnmarshalJSONOrFile(config.Static, &m.static); err != nil {
+			return nil, fmt.Errorf("failed to parse BOSH metadata: %s", err)
+		}
+	}
+
+	if config.DirectorURL != "" {
+		m.httpClient = cfhttp.NewClient()
+		m.httpClient.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: config.SkipSSL, MinVersion: tls.VersionTLS12},
+		}
+	}
+
+	m.deployments, m.instances = m.index(nil, nil)
+	return m, nil
+}
+
+// Open fetches the deployments and instances from the director and refreshes
+// them every refresh interval
+func (m *Metadata) Open() error {
+	if m.httpClient == nil {
+		return nil
+	}
+
+	if err := m.refresh(); err != nil {
+		m.config.Logger.Error("Failed to fetch BOSH deployments", err)
+	}
+
+	if m.config.RefreshInterval > 0 {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+
+			ticker := time.NewTicker(m.config.RefreshInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if err := m.refresh(); err != nil {
+						m.config.Logger.Error("Failed to refresh BOSH deployments", err)
+					}
+				case <-m.closing:
+					return
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+func (m *Metadata) Close() error {
+	close(m.closing)
+	m.wg.Wait()
+	return nil
+}
+
+// Annotate adds bosh_product, bosh_product_version, bosh_instance_group and
+// bosh_az to fields from their deployment, job, index and ip
+func (m *Metadata) Annotate(fields map[string]interface{}) {
+	deployment, _ := fields["deployment"].(string)
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if d, ok := m.deployments[deployment]; ok {
+		setIfNotEmpty(fields, "bosh_product", d.Product)
+		setIfNotEmpty(fields, "bosh_product_version", d.Version)
+	}
+
+	keys := []string{
+		fmt.Sprintf("%v", fields["ip"]),
+		fmt.Sprintf("%s/%v", deployment, fields["job_index"]),
+		fmt.Sprintf("%s/%v/%v", deployment, fields["job"], fields["job_index"]),
+	}
+	for _, key := range keys {
+		if instance, ok := m.instances[key]; ok {
+			setIfNotEmpty(fields, "bosh_instance_group", instance.InstanceGroup)
+			setIfNotEmpty(fields, "bosh_az", instance.AZ)
+			return
+		}
+	}
+}
+
+// refresh replaces the deployments and instances with the director's
+func (m *Metadata) refresh() error {
+	var remoteDeployments []struct {
+		Name     string `json:"name"`
+		Releases []struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"releases"`
+	}
+	if err := m.get("/deployments", &remoteDeployments); err != nil {
+		return err
+	}
+
+	deployments := make(map[string]Deployment)
+	var instances []Instance
+	for _, d := range remoteDeployments {
+		product := productSuffix.ReplaceAllString(d.Name, "")
+		deployment := Deployment{Product: product}
+		for _, release := range d.Releases {
+			if release.Name == product {
+				deployment.Version = release.Version
+			}
+		}
+		deployments[d.Name] = deployment
+
+		var deploymentInstances []Instance
+		if err := m.get("/deployments/"+url.PathEscape(d.Name)+"/instances", &deploymentInstances); err != nil {
+			return err
+		}
+		for _, instance := range deploymentInstances {
+			instance.Deployment = d.Name
+			instances = append(instances, instance)
+		}
+	}
+
+	indexedDeployments, indexedInstances := m.index(deployments, instances)
+	m.mutex.Lock()
+	m.deployments = indexedDeployments
+	m.instances = indexedInstances
+	m.mutex.Unlock()
+	return nil
+}
+
+// index merges the static mapping over the given deployments and instances
+func (m *Metadata) index(deployments map[string]Deployment, instances []Instance) (map[string]Deployment, map[string]Instance) {
+	indexedDeployments := make(map[string]Deployment)
+	for name, d := range deployments {
+		indexedDeployments[name] = d
+	}
+	for name, d := range m.static.Deployments {
+		indexedDeployments[name] = d
+	}
+
+	indexedInstances := make(map[string]Instance)
+	for _, instance := range append(instances, m.static.Instances...) {
+		for _, ip := range instance.IPs {
+			indexedInstances[ip] = instance
+		}
+		if instance.ID != "" {
+			indexedInstances[instance.Deployment+"/"+instance.ID] = instance
+		}
+		if instance.Index != nil {
+			index := strconv.Itoa(*instance.Index)
+			indexedInstances[instance.Deployment+"/"+index] = instance
+			indexedInstances[instance.Deployment+"/"+instance.InstanceGroup+"/"+index] = instance
+		}
+	}
+	return indexedDeployments, indexedInstances
+}
+
+func (m *Metadata) get(path string, v interface{}) error {
+	req, err := http.NewRequest("GET", strings.TrimRight(m.config.DirectorURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	if m.config.Username != "" {
+		req.SetBasicAuth(m.config.Username, m.config.Password)
+	}
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Non-ok response code [%d] from BOSH director for %s: %s", resp.StatusCode, path, body)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func setIfNotEmpty(fields map[string]interface{}, key, value string) {
+	if value != "" {
+		fields[key] = value
+	}
+}
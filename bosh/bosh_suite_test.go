@@ -0,0 +1,13 @@
+package bosh_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestBosh(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Bosh Suite")
+}
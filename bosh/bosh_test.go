@@ -0,0 +1,108 @@
+package bosh_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/bosh"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metadata", func() {
+	var (
+		server *httptest.Server
+		config *bosh.Config
+		fields map[string]interface{}
+	)
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, password, _ := r.BasicAuth()
+			if user != "admin" || password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			switch r.URL.Path {
+			case "/deployments":
+				w.Write([]byte(`[{"name":"cf-0123456789abcdef0123","releases":[{"name":"diego","version":"2.1"},{"name":"cf","version":"4.0.1"}]}]`))
+			case "/deployments/cf-0123456789abcdef0123/instances":
+				w.Write([]byte(`[{"job":"diego_cell","id":"c0ffee","index":0,"az":"z1","ips":["10.0.0.5"]},{"job":"router","id":"beef","index":1,"az":"z2","ips":["10.0.0.6"]}]`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		config = &bosh.Config{
+			DirectorURL: server.URL,
+			Username:    "admin",
+			Password:    "secret",
+			Logger:      lager.NewLogger("test"),
+		}
+		fields = map[string]interface{}{"deployment": "cf-0123456789abcdef0123", "job": "router", "job_index": "beef", "ip": "10.0.0.6"}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("annotates events from the director", func() {
+		metadata, err := bosh.NewMetadata(config)
+		Ω(err).ShouldNot(HaveOccurred())
+		metadata.Open()
+		defer metadata.Close()
+
+		metadata.Annotate(fields)
+		Expect(fields["bosh_product"]).To(Equal("cf"))
+		Expect(fields["bosh_product_version"]).To(Equal("4.0.1"))
+		Expect(fields["bosh_instance_group"]).To(Equal("router"))
+		Expect(fields["bosh_az"]).To(Equal("z2"))
+	})
+
+	It("matches instances by job and index without ip", func() {
+		metadata, err := bosh.NewMetadata(config)
+		Ω(err).ShouldNot(HaveOccurred())
+		metadata.Open()
+		defer metadata.Close()
+
+		fields = map[string]interface{}{"deployment": "cf-0123456789abcdef0123", "job": "diego_cell", "job_index": "0"}
+		metadata.Annotate(fields)
+		Expect(fields["bosh_instance_group"]).To(Equal("diego_cell"))
+		Expect(fields["bosh_az"]).To(Equal("z1"))
+	})
+
+	It("overrides the director with the static metadata", func() {
+		config.Static = `{"deployments":{"cf-0123456789abcdef0123":{"product":"elastic-runtime","version":"4.0"}},"instances":[{"deployment":"cf-0123456789abcdef0123","job":"gorouter","az":"z3","ips":["10.0.0.6"]}]}`
+		metadata, err := bosh.NewMetadata(config)
+		Ω(err).ShouldNot(HaveOccurred())
+		metadata.Open()
+		defer metadata.Close()
+
+		metadata.Annotate(fields)
+		Expect(fields["bosh_product"]).To(Equal("elastic-runtime"))
+		Expect(fields["bosh_product_version"]).To(Equal("4.0"))
+		Expect(fields["bosh_instance_group"]).To(Equal("gorouter"))
+		Expect(fields["bosh_az"]).To(Equal("z3"))
+	})
+
+	It("annotates from the static metadata when the director fails", func() {
+		config.Password = "wrong"
+		config.Static = `{"deployments":{"cf-0123456789abcdef0123":{"product":"cf"}}}`
+		metadata, err := bosh.NewMetadata(config)
+		Ω(err).ShouldNot(HaveOccurred())
+		metadata.Open()
+		defer metadata.Close()
+
+		metadata.Annotate(fields)
+		Expect(fields["bosh_product"]).To(Equal("cf"))
+		Expect(fields).NotTo(HaveKey("bosh_product_version"))
+		Expect(fields).NotTo(HaveKey("bosh_az"))
+	})
+
+	It("fails on invalid static metadata", func() {
+		config.Static = `{"deployments":`
+		_, err := bosh.NewMetadata(config)
+		Ω(err).Should(HaveOccurred())
+	})
+})
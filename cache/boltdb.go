@@ -31,21 +31,26 @@ type BoltdbConfig struct {
 	AppCacheTTL        time.Duration
 	OrgSpaceCacheTTL   time.Duration
 	AppLimits          int
+	UseMetadata        bool
 
 	Logger lager.Logger
 }
 
 // Org is a CAPI org
 type Org struct {
-	Name        string
-	LastUpdated time.Time
+	Name            string
+	Metadata        map[string]string
+	LastUpdated     time.Time
+	MetadataUpdated time.Time // metadata is refreshed with the apps
 }
 
 // Space is a CAPI space with a reference to its org's GUID
 type Space struct {
-	Name        string
-	OrgGUID     string
-	LastUpdated time.Time
+	Name            string
+	OrgGUID         string
+	Metadata        map[string]string
+	LastUpdated     time.Time
+	MetadataUpdated time.Time // metadata is refreshed with the apps
 }
 
 type Boltdb struct {
@@ -317,9 +322,18 @@ func (c *Boltdb) getAllAppsFromRemote() (map[string]*App, error) {
 		return nil, err
 	}
 
+	labels, err := c.getAllAppLabelsFromRemote()
+	if err != nil {
+		c.config.Logger.Error("Unable to fetch app metadata from remote, keeping cached labels", err)
+	}
+
 	apps := make(map[string]*App, len(cfApps))
 	for i := range cfApps {
-		app := c.fromPCFApp(&cfApps[i])
+		appLabels := labels[cfApps[i].Guid]
+		if err != nil {
+			appLabels = c.getCachedAppLabels(cfApps[i].Guid)
+		}
+		app := c.fromPCFApp(&cfApps[i], appLabels)
 		apps[app.Guid] = app
 	}
 
@@ -413,13 +427,53 @@ func (c *Boltdb) fillDatabase(apps map[string]*App) {
 	}
 }
 
-func (c *Boltdb) fromPCFApp(app *cfclient.App) *App {
+// getAllAppLabelsFromRemote returns the nozzle metadata of all apps keyed by
+// app GUID. App labels are only exposed by the v3 API, so they are retrieved
+// with a separate listing when UseMetadata is enabled.
+func (c *Boltdb) getAllAppLabelsFromRemote() (map[string]map[string]string, error) {
+	labels := make(map[string]map[string]string)
+	if !c.config.UseMetadata {
+		return labels, nil
+	}
+
+	q := url.Values{}
+	q.Set("per_page", "5000")
+	v3Apps, err := c.appClient.ListV3AppsByQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range v3Apps {
+		labels[v3Apps[i].GUID] = fromV3Metadata(v3Apps[i].Metadata)
+	}
+	return labels, nil
+}
+
+// getCachedAppLabels returns the labels the app was last cached with, so that
+// a failed metadata refresh doesn't drop its opt-out and routing labels
+func (c *Boltdb) getCachedAppLabels(appGuid string) map[string]string {
+	c.lock.RLock()
+	app, ok := c.cache[appGuid]
+	c.lock.RUnlock()
+	if ok {
+		return app.Labels
+	}
+
+	app, _ = c.getAppFromDatabase(appGuid)
+	if app != nil {
+		return app.Labels
+	}
+	return nil
+}
+
+func (c *Boltdb) fromPCFApp(app *cfclient.App, labels map[string]string) *App {
 	cachedApp := &App{
 		Name:       app.Name,
 		Guid:       app.Guid,
 		SpaceGuid:  app.SpaceGuid,
 		IgnoredApp: c.isOptOut(app.Environment),
 		CfAppEnv:   app.Environment,
+		Labels:     labels,
 	}
 
 	c.fillOrgAndSpace(cachedApp)
@@ -434,16 +488,26 @@ func (c *Boltdb) fillOrgAndSpace(app *App) error {
 	space, ok := c.spaceNameCache[app.SpaceGuid]
 	c.lock.RUnlock()
 
-	if !ok || now.Sub(space.LastUpdated) > c.config.OrgSpaceCacheTTL {
-		cfspace, err := c.appClient.GetSpaceByGuid(app.SpaceGuid)
-		if err != nil {
-			return err
+	if !ok || now.Sub(space.LastUpdated) > c.config.OrgSpaceCacheTTL || c.metadataExpired(space.MetadataUpdated, now) {
+		if !ok || now.Sub(space.LastUpdated) > c.config.OrgSpaceCacheTTL {
+			cfspace, err := c.appClient.GetSpaceByGuid(app.SpaceGuid)
+			if err != nil {
+				return err
+			}
+			space.Name = cfspace.Name
+			space.OrgGUID = cfspace.OrganizationGuid
+			space.LastUpdated = now
 		}
 
-		space = Space{
-			Name:        cfspace.Name,
-			OrgGUID:     cfspace.OrganizationGuid,
-			LastUpdated: now,
+		if c.metadataExpired(space.MetadataUpdated, now) {
+			// Metadata is optional, e.g. older CAPI, the space keeps its last labels
+			md, err := c.appClient.SpaceMetadata(app.SpaceGuid)
+			if err != nil {
+				c.config.Logger.Error("Unable to fetch space metadata from remote", err, lager.Data{"space_guid": app.SpaceGuid})
+			} else {
+				space.Metadata = fromMetadata(md)
+			}
+			space.MetadataUpdated = now
 		}
 
 		c.lock.Lock()
@@ -457,15 +521,24 @@ func (c *Boltdb) fillOrgAndSpace(app *App) error {
 	c.lock.RLock()
 	org, ok := c.orgNameCache[space.OrgGUID]
 	c.lock.RUnlock()
-	if !ok || now.Sub(org.LastUpdated) > c.config.OrgSpaceCacheTTL {
-		cforg, err := c.appClient.GetOrgByGuid(space.OrgGUID)
-		if err != nil {
-			return err
+	if !ok || now.Sub(org.LastUpdated) > c.config.OrgSpaceCacheTTL || c.metadataExpired(org.MetadataUpdated, now) {
+		if !ok || now.Sub(org.LastUpdated) > c.config.OrgSpaceCacheTTL {
+			cforg, err := c.appClient.GetOrgByGuid(space.OrgGUID)
+			if err != nil {
+				return err
+			}
+			org.Name = cforg.Name
+			org.LastUpdated = now
 		}
 
-		org = Org{
-			Name:        cforg.Name,
-			LastUpdated: now,
+		if c.metadataExpired(org.MetadataUpdated, now) {
+			md, err := c.appClient.OrgMetadata(space.OrgGUID)
+			if err != nil {
+				c.config.Logger.Error("Unable to fetch org metadata from remote", err, lager.Data{"org_guid": space.OrgGUID})
+			} else {
+				org.Metadata = fromMetadata(md)
+			}
+			org.MetadataUpdated = now
 		}
 
 		c.lock.Lock()
@@ -476,15 +549,41 @@ func (c *Boltdb) fillOrgAndSpace(app *App) error {
 	app.OrgGuid = space.OrgGUID
 	app.OrgName = org.Name
 
+	// Org and space metadata are inherited by the app
+	app.Metadata = mergeMetadata(org.Metadata, space.Metadata, app.Labels)
+	app.IgnoredApp = c.isOptOut(app.CfAppEnv) || isMetadataOptOut(app.Metadata)
+
 	return nil
 }
 
+// metadataExpired tells whether org or space metadata last fetched at updated
+// is due for a refresh. Metadata follows the app refresh rather than the org
+// and space name TTL, the latter applying when apps are not refreshed.
+func (c *Boltdb) metadataExpired(updated, now time.Time) bool {
+	ttl := c.config.AppCacheTTL
+	if ttl == 0 {
+		ttl = c.config.OrgSpaceCacheTTL
+	}
+	return c.config.UseMetadata && now.Sub(updated) > ttl
+}
+
 func (c *Boltdb) getAppFromRemote(appGuid string) (*App, error) {
 	cfApp, err := c.appClient.AppByGuid(appGuid)
 	if err != nil {
 		return nil, err
 	}
-	app := c.fromPCFApp(&cfApp)
+	var labels map[string]string
+	if c.config.UseMetadata {
+		v3App, err := c.appClient.GetV3AppByGUID(appGuid)
+		if err != nil {
+			c.config.Logger.Error("Unable to fetch app metadata from remote, keeping cached labels", err, lager.Data{"app_guid": appGuid})
+			labels = c.getCachedAppLabels(appGuid)
+		} else {
+			labels = fromV3Metadata(v3App.Metadata)
+		}
+	}
+
+	app := c.fromPCFApp(&cfApp, labels)
 	c.fillDatabase(map[string]*App{app.Guid: app})
 
 	return app, nil
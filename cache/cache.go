@@ -15,6 +15,12 @@ type App struct {
 	OrgGuid    string
 	CfAppEnv   map[string]interface{}
 	IgnoredApp bool
+
+	// Labels holds the app's own nozzle metadata (v3 labels and annotations)
+	Labels map[string]string
+	// Metadata holds the nozzle metadata resolved from org, space and app,
+	// where app overrides space and space overrides org
+	Metadata map[string]string
 }
 
 type Cache interface {
@@ -30,4 +36,8 @@ type AppClient interface {
 	ListAppsByQueryWithLimits(query url.Values, totalPages int) ([]cfclient.App, error)
 	GetSpaceByGuid(spaceGUID string) (cfclient.Space, error)
 	GetOrgByGuid(orgGUID string) (cfclient.Org, error)
+	ListV3AppsByQuery(query url.Values) ([]cfclient.V3App, error)
+	GetV3AppByGUID(guid string) (*cfclient.V3App, error)
+	OrgMetadata(orgGUID string) (*cfclient.Metadata, error)
+	SpaceMetadata(spaceGUID string) (*cfclient.Metadata, error)
 }
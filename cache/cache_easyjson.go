@@ -47,6 +47,10 @@ func easyjsonA591d1bcDecodeGithubComCloudfoundryCommunitySplunkFirehoseNozzleCac
 			parseCfAppEnv(in, out)
 		case "IgnoredApp":
 			out.IgnoredApp = bool(in.Bool())
+		case "Labels":
+			out.Labels = parseStringMap(in)
+		case "Metadata":
+			out.Metadata = parseStringMap(in)
 		default:
 			in.SkipRecursive()
 		}
@@ -76,6 +80,42 @@ func parseCfAppEnv(in *jlexer.Lexer, out *App) {
 	}
 }
 
+func parseStringMap(in *jlexer.Lexer) map[string]string {
+	if in.IsNull() {
+		in.Skip()
+		return nil
+	}
+	in.Delim('{')
+	out := make(map[string]string)
+	for !in.IsDelim('}') {
+		key := string(in.String())
+		in.WantColon()
+		out[key] = string(in.String())
+		in.WantComma()
+	}
+	in.Delim('}')
+	return out
+}
+
+func encodeStringMap(out *jwriter.Writer, in map[string]string) {
+	if in == nil {
+		out.RawString(`null`)
+		return
+	}
+	out.RawByte('{')
+	first := true
+	for k, v := range in {
+		if !first {
+			out.RawByte(',')
+		}
+		first = false
+		out.String(k)
+		out.RawByte(':')
+		out.String(v)
+	}
+	out.RawByte('}')
+}
+
 func easyjsonA591d1bcEncodeGithubComCloudfoundryCommunitySplunkFirehoseNozzleCache(out *jwriter.Writer, in App) {
 	out.RawByte('{')
 	first := true
@@ -143,6 +183,10 @@ func easyjsonA591d1bcEncodeGithubComCloudfoundryCommunitySplunkFirehoseNozzleCac
 	first = false
 	out.RawString("\"IgnoredApp\":")
 	out.Bool(bool(in.IgnoredApp))
+	out.RawString(",\"Labels\":")
+	encodeStringMap(out, in.Labels)
+	out.RawString(",\"Metadata\":")
+	encodeStringMap(out, in.Metadata)
 	out.RawByte('}')
 }
 
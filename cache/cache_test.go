@@ -1,6 +1,7 @@
 package cache_test
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"time"
@@ -225,6 +226,122 @@ var _ = Describe("Cache", func() {
 		})
 	})
 
+	Context("CF metadata", func() {
+		var (
+			config *BoltdbConfig
+			cache  *Boltdb
+			client *testing.AppClientMock
+		)
+
+		BeforeEach(func() {
+			boltdbPath := "/tmp/boltdb3"
+			config = &BoltdbConfig{
+				Path:             boltdbPath,
+				OrgSpaceCacheTTL: 48 * time.Hour,
+				UseMetadata:      true,
+				Logger:           lager.NewLogger("test"),
+			}
+
+			client = testing.NewAppClientMock(n)
+			client.SetOrgLabels("cf_org_id_0", map[string]string{
+				MetadataIndex:      "org_index",
+				MetadataSourcetype: "org:logs",
+			})
+			client.SetSpaceLabels("cf_space_id_0", map[string]string{
+				MetadataIndex: "space_index",
+				"other/label": "ignored",
+			})
+			client.SetAppLabels("cf_app_id_1", map[string]string{
+				MetadataDisableLogging: "true",
+			})
+
+			os.Remove(boltdbPath)
+			cache, gerr = NewBoltdb(client, config)
+			Ω(gerr).ShouldNot(HaveOccurred())
+
+			gerr = cache.Open()
+			Ω(gerr).ShouldNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			cache.Close()
+			os.Remove(config.Path)
+		})
+
+		It("Inherits org and space metadata", func() {
+			app, err := cache.GetApp("cf_app_id_0")
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(app.Metadata).To(Equal(map[string]string{
+				MetadataIndex:      "space_index",
+				MetadataSourcetype: "org:logs",
+			}))
+			Expect(app.IgnoredApp).To(BeFalse())
+		})
+
+		It("Opts out apps by label", func() {
+			app, err := cache.GetApp("cf_app_id_1")
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(app.IgnoredApp).To(BeTrue())
+		})
+
+		It("Picks up label changes on refresh", func() {
+			client.SetAppLabels("cf_app_id_1", map[string]string{})
+			cache.ManuallyInvalidateCaches()
+
+			app, err := cache.GetApp("cf_app_id_1")
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(app.IgnoredApp).To(BeFalse())
+		})
+
+		It("Keeps cached app labels when app metadata is unavailable", func() {
+			client.SetAppMetadataError(errors.New("502 Bad Gateway"))
+			client.SetAppLabels("cf_app_id_1", map[string]string{})
+			Ω(cache.ManuallyInvalidateCaches()).ShouldNot(HaveOccurred())
+
+			app, err := cache.GetApp("cf_app_id_1")
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(app.IgnoredApp).To(BeTrue())
+			Expect(app.Labels).To(HaveKeyWithValue(MetadataDisableLogging, "true"))
+		})
+
+		It("Keeps stored app labels when fetching an app's metadata fails", func() {
+			// Drop the app from the in-memory cache, the database keeps it
+			client.DeleteApp("cf_app_id_1")
+			Ω(cache.ManuallyInvalidateCaches()).ShouldNot(HaveOccurred())
+			client.CreateApp("cf_app_id_1", "cf_space_id_1")
+			client.SetAppMetadataError(errors.New("502 Bad Gateway"))
+
+			app, err := cache.GetApp("cf_app_id_1")
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(app.IgnoredApp).To(BeTrue())
+		})
+
+		It("Keeps org and space names when metadata is unavailable", func() {
+			client.SetMetadataError(errors.New("403 Forbidden"))
+			Ω(cache.ManuallyInvalidateCaches()).ShouldNot(HaveOccurred())
+
+			app, err := cache.GetApp("cf_app_id_0")
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(app.SpaceName).To(Equal("cf_space_name_0"))
+			Expect(app.OrgName).To(Equal("cf_org_name_0"))
+			Expect(app.Metadata).To(BeEmpty())
+		})
+
+		It("Refreshes org and space labels with the apps", func() {
+			cache.Close()
+			config.AppCacheTTL = 50 * time.Millisecond
+			cache, gerr = NewBoltdb(client, config)
+			Ω(gerr).ShouldNot(HaveOccurred())
+			Ω(cache.Open()).ShouldNot(HaveOccurred())
+
+			client.SetSpaceLabels("cf_space_id_0", map[string]string{MetadataIndex: "new_index"})
+			Eventually(func() string {
+				app, _ := cache.GetApp("cf_app_id_0")
+				return app.Metadata[MetadataIndex]
+			}).Should(Equal("new_index"))
+		})
+	})
+
 	Context("NewBoltdb error", func() {
 		It("Expect error", func() {
 			dup := *config
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+
+	cfclient "github.com/cloudfoundry-community/go-cfclient"
+)
+
+// Metadata keys recognized on apps, spaces and orgs. Both v3 labels and
+// annotations are honoured; annotations win over labels with the same key
+// since label values can't hold characters like ':' (used by sourcetypes).
+const (
+	MetadataPrefix         = "f2s.splunk.com/"
+	MetadataDisableLogging = MetadataPrefix + "disable-logging"
+	MetadataIndex          = MetadataPrefix + "index"
+	MetadataSourcetype     = MetadataPrefix + "sourcetype"
+	MetadataSamplingRate   = MetadataPrefix + "sampling-rate"
+	MetadataTenant         = MetadataPrefix + "tenant"
+)
+
+// nozzleMetadata picks nozzle specific keys out of v3 labels and annotations
+func nozzleMetadata(labels, annotations map[string]string) map[string]string {
+	md := make(map[string]string)
+	for _, kv := range []map[string]string{labels, annotations} {
+		for k, v := range kv {
+			if strings.HasPrefix(k, MetadataPrefix) && v != "" {
+				md[k] = v
+			}
+		}
+	}
+	return md
+}
+
+func fromV3Metadata(metadata cfclient.V3Metadata) map[string]string {
+	return nozzleMetadata(metadata.Labels, metadata.Annotations)
+}
+
+func fromMetadata(metadata *cfclient.Metadata) map[string]string {
+	if metadata == nil {
+		return map[string]string{}
+	}
+	return nozzleMetadata(toStringMap(metadata.Labels), toStringMap(metadata.Annotations))
+}
+
+func toStringMap(m map[string]interface{}) map[string]string {
+	r := make(map[string]string, len(m))
+	for k, v := range m {
+		if v != nil {
+			r[k] = fmt.Sprintf("%v", v)
+		}
+	}
+	return r
+}
+
+// mergeMetadata merges metadata maps, later maps override earlier ones
+func mergeMetadata(mds ...map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for _, md := range mds {
+		for k, v := range md {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+func isMetadataOptOut(md map[string]string) bool {
+	return strings.ToLower(md[MetadataDisableLogging]) == "true"
+}
@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	cfclient "github.com/cloudfoundry-community/go-cfclient"
+)
+
+// UserProvidedOffering is the offering of user provided service instances
+const UserProvidedOffering = "user-provided"
+
+// ServiceInstance is a service instance bound to an app
+type ServiceInstance struct {
+	Guid     string
+	Name     string
+	Offering string
+	Plan     string
+}
+
+type ServiceBindingClient interface {
+	ListServiceBindingsByQuery(query url.Values) ([]cfclient.ServiceBinding, error)
+	GetServiceInstanceByGuid(guid string) (cfclient.ServiceInstance, error)
+	GetUserProvidedServiceInstanceByGuid(guid string) (cfclient.UserProvidedServiceInstance, error)
+	GetServicePlanByGUID(guid string) (*cfclient.ServicePlan, error)
+	GetServiceByGuid(guid string) (cfclient.Service, error)
+}
+
+type ServiceBindingsConfig struct {
+	TTL      time.Duration
+	ErrorTTL time.Duration // how long failed refreshes are cached, 0 to retry on every event
+	Logger   lager.Logger
+}
+
+type cachedServiceInstances struct {
+	instances []ServiceInstance
+	err       error // error of the first fetch, until it succeeds
+	expires   time.Time
+}
+
+type cachedServiceInstance struct {
+	instance    ServiceInstance
+	lastUpdated time.Time
+}
+
+// ServiceBindings caches the service instances bound to apps. Bindings and
+// service instances are refreshed from remote once older than TTL, and kept
+// when the refresh fails. Failed refreshes are retried after ErrorTTL.
+type ServiceBindings struct {
+	client ServiceBindingClient
+	config *ServiceBindingsConfig
+
+	lock      sync.RWMutex
+	apps      map[string]cachedServiceInstances // app guid -> bound service instances
+	instances map[string]cachedServiceInstance  // service instance guid -> service instance
+}
+
+func NewServiceBindings(client ServiceBindingClient, config *ServiceBindingsConfig) *ServiceBindings {
+	return &ServiceBindings{
+		client:    client,
+		config:    config,
+		apps:      make(map[string]cachedServiceInstances),
+		instances: make(map[string]cachedServiceInstance),
+	}
+}
+
+// GetServiceInstances returns the service instances bound to the app. Failed
+// refreshes are logged once, not on every call while their error is cached.
+func (c *ServiceBindings) GetServiceInstances(appGuid string) ([]ServiceInstance, error) {
+	now := time.Now()
+
+	c.lock.RLock()
+	cached, ok := c.apps[appGuid]
+	c.lock.RUnlock()
+	if ok && now.Before(cached.expires) {
+		return cached.instances, cached.err
+	}
+
+	instances, err := c.getServiceInstancesFromRemote(appGuid, now)
+	if err != nil {
+		// Cache the old bindings or the error, so that remote isn't queried
+		// on every event of the app while failing
+		failed := cachedServiceInstances{err: err, expires: now.Add(c.config.ErrorTTL)}
+		if ok && cached.err == nil {
+			c.config.Logger.Error(fmt.Sprint("Using old service bindings for cf_app_id ", appGuid), err)
+			failed = cachedServiceInstances{instances: cached.instances, expires: failed.expires}
+		} else {
+			c.config.Logger.Error(fmt.Sprint("Failed to fetch service bindings for cf_app_id ", appGuid), err)
+		}
+		c.lock.Lock()
+		c.apps[appGuid] = failed
+		c.lock.Unlock()
+		return failed.instances, failed.err
+	}
+
+	c.lock.Lock()
+	c.apps[appGuid] = cachedServiceInstances{instances: instances, expires: now.Add(c.config.TTL)}
+	c.lock.Unlock()
+
+	return instances, nil
+}
+
+func (c *ServiceBindings) getServiceInstancesFromRemote(appGuid string, now time.Time) ([]ServiceInstance, error) {
+	q := url.Values{}
+	q.Set("q", "app_guid:"+appGuid)
+	bindings, err := c.client.ListServiceBindingsByQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]ServiceInstance, 0, len(bindings))
+	for _, binding := range bindings {
+		instance, err := c.getServiceInstance(binding.ServiceInstanceGuid, now)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+func (c *ServiceBindings) getServiceInstance(guid string, now time.Time) (ServiceInstance, error) {
+	c.lock.RLock()
+	cached, ok := c.instances[guid]
+	c.lock.RUnlock()
+	if ok && now.Sub(cached.lastUpdated) < c.config.TTL {
+		return cached.instance, nil
+	}
+
+	instance := ServiceInstance{Guid: guid}
+	si, err := c.client.GetServiceInstanceByGuid(guid)
+	if err == nil {
+		instance.Name = si.Name
+		service, err := c.client.GetServiceByGuid(si.ServiceGuid)
+		if err != nil {
+			return instance, err
+		}
+		instance.Offering = service.Label
+
+		plan, err := c.client.GetServicePlanByGUID(si.ServicePlanGuid)
+		if err != nil {
+			return instance, err
+		}
+		instance.Plan = plan.Name
+	} else {
+		// Managed service instances don't list user provided ones
+		ups, upsErr := c.client.GetUserProvidedServiceInstanceByGuid(guid)
+		if upsErr != nil {
+			return instance, err
+		}
+		instance.Name = ups.Name
+		instance.Offering = UserProvidedOffering
+	}
+
+	c.lock.Lock()
+	c.instances[guid] = cachedServiceInstance{instance: instance, lastUpdated: now}
+	c.lock.Unlock()
+
+	return instance, nil
+}
@@ -0,0 +1,115 @@
+package cache_test
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+
+	. "github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ServiceBindings", func() {
+	var (
+		client          *testing.ServiceBindingClientMock
+		config          *ServiceBindingsConfig
+		serviceBindings *ServiceBindings
+	)
+
+	BeforeEach(func() {
+		client = testing.NewServiceBindingClientMock()
+		client.CreateServiceInstance("si-1", "orders-db", "postgres", "small")
+		client.CreateUserProvidedServiceInstance("si-2", "log-drain")
+		client.Bind("app-1", "si-1")
+		client.Bind("app-1", "si-2")
+		client.Bind("app-2", "si-1")
+
+		config = &ServiceBindingsConfig{TTL: time.Hour, Logger: lager.NewLogger("test")}
+		serviceBindings = NewServiceBindings(client, config)
+	})
+
+	It("returns the managed and user provided service instances bound to the app", func() {
+		instances, err := serviceBindings.GetServiceInstances("app-1")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(instances).To(Equal([]ServiceInstance{
+			{Guid: "si-1", Name: "orders-db", Offering: "postgres", Plan: "small"},
+			{Guid: "si-2", Name: "log-drain", Offering: UserProvidedOffering},
+		}))
+	})
+
+	It("returns no service instance for unbound apps", func() {
+		instances, err := serviceBindings.GetServiceInstances("app-3")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(instances).To(BeEmpty())
+	})
+
+	It("caches bindings and service instances until TTL", func() {
+		serviceBindings.GetServiceInstances("app-1")
+		serviceBindings.GetServiceInstances("app-1")
+		serviceBindings.GetServiceInstances("app-2")
+		Expect(client.ListServiceBindingsCallCount()).To(Equal(2))
+		Expect(client.GetServiceInstanceCallCount()).To(Equal(2))
+	})
+
+	It("refreshes bindings after TTL", func() {
+		config.TTL = 100 * time.Millisecond
+		serviceBindings.GetServiceInstances("app-2")
+		client.CreateServiceInstance("si-3", "cache", "redis", "shared")
+		client.Bind("app-2", "si-3")
+
+		time.Sleep(150 * time.Millisecond)
+		instances, err := serviceBindings.GetServiceInstances("app-2")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(instances).To(HaveLen(2))
+		Expect(instances[1].Name).To(Equal("cache"))
+	})
+
+	It("keeps the cached bindings when the refresh fails", func() {
+		config.TTL = 100 * time.Millisecond
+		serviceBindings.GetServiceInstances("app-2")
+		client.SetFail(true)
+
+		time.Sleep(150 * time.Millisecond)
+		instances, err := serviceBindings.GetServiceInstances("app-2")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(instances).To(HaveLen(1))
+
+		_, err = serviceBindings.GetServiceInstances("app-3")
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("caches failed refreshes until error TTL", func() {
+		config.ErrorTTL = 100 * time.Millisecond
+		client.SetFail(true)
+		_, err := serviceBindings.GetServiceInstances("app-1")
+		Ω(err).Should(HaveOccurred())
+		_, err = serviceBindings.GetServiceInstances("app-1")
+		Ω(err).Should(HaveOccurred())
+		Expect(client.ListServiceBindingsCallCount()).To(Equal(1))
+
+		client.SetFail(false)
+		time.Sleep(150 * time.Millisecond)
+		instances, err := serviceBindings.GetServiceInstances("app-1")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(instances).To(HaveLen(2))
+		Expect(client.ListServiceBindingsCallCount()).To(Equal(2))
+	})
+
+	It("logs failed refreshes once until error TTL", func() {
+		var logs bytes.Buffer
+		config.Logger = lager.NewLogger("test")
+		config.Logger.RegisterSink(lager.NewWriterSink(&logs, lager.INFO))
+		config.ErrorTTL = time.Hour
+		client.SetFail(true)
+		for i := 0; i < 3; i++ {
+			_, err := serviceBindings.GetServiceInstances("app-1")
+			Ω(err).Should(HaveOccurred())
+		}
+		Expect(strings.Count(logs.String(), "Failed to fetch service bindings for cf_app_id app-1")).To(Equal(1))
+	})
+})
@@ -0,0 +1,194 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
+)
+
+// Error types of the records besides http_<status code>
+const (
+	ErrorTypeNetwork     = "network"      // batches which didn't get a HEC response
+	ErrorTypeBreakerOpen = "breaker_open" // batches diverted while the circuit breaker is open
+)
+
+const recordExt = ".json"
+
+// ErrFull is returned by Add when the record would exceed the maximum size of the store
+var ErrFull = errors.New("dead-letter store is full")
+
+// Record is a batch which could not be sent to Splunk
+type Record struct {
+	Time       time.Time                `json:"time"`
+	Tenant     string                   `json:"tenant,omitempty"`  // HEC tenant, empty for the default destination
+	Metrics    bool                     `json:"metrics,omitempty"` // batch of the metrics index
+	Index      string                   `json:"index,omitempty"`   // index of the batch, empty for the default index
+	Error      string                   `json:"error"`
+	ErrorType  string                   `json:"error_type"`
+	StatusCode int                      `json:"status_code,omitempty"`
+	Response   string                   `json:"response,omitempty"` // HEC response body
+	Events     []map[string]interface{} `json:"events"`
+}
+
+// NewRecord creates the record of a batch which failed with err
+func NewRecord(events []map[string]interface{}, err error) *Record {
+	record := &Record{
+		Time:      time.Now().UTC(),
+		Error:     fmt.Sprint(err),
+		ErrorType: ErrorTypeNetwork,
+		Events:    events,
+	}
+	var hecErr *eventwriter.HECError
+	if errors.As(err, &hecErr) {
+		record.ErrorType = fmt.Sprintf("http_%d", hecErr.StatusCode)
+		record.StatusCode = hecErr.StatusCode
+		record.Response = hecErr.Body
+	}
+	return record
+}
+
+// Filter selects records by time range and error type
+type Filter struct {
+	From       time.Time // zero means no lower bound
+	To         time.Time // zero means no upper bound
+	ErrorTypes []string  // error types like http_503 or classes like http_5xx, empty matches all
+}
+
+func (f *Filter) Matches(record *Record) bool {
+	if !f.From.IsZero() && record.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !record.Time.Before(f.To) {
+		return false
+	}
+	if len(f.ErrorTypes) == 0 {
+		return true
+	}
+	for _, errorType := range f.ErrorTypes {
+		if errorType == record.ErrorType {
+			return true
+		}
+		// http_5xx matches http_500 to http_599
+		if strings.HasSuffix(errorType, "xx") && len(errorType) == len(record.ErrorType) &&
+			strings.HasPrefix(record.ErrorType, strings.TrimSuffix(errorType, "xx")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Store keeps dead-letter records as JSON files of a directory
+type Store struct {
+	dir     string
+	maxSize int64 // bytes of the records, 0 means unlimited
+	seq     uint64
+
+	lock sync.Mutex
+	size int64
+}
+
+// NewStore opens the store of dir. Records which would grow the store over
+// maxSize bytes are rejected, 0 means unlimited.
+func NewStore(dir string, maxSize int64) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create dead-letter directory: %s", err)
+	}
+
+	store := &Store{dir: dir, maxSize: maxSize}
+	paths, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			store.size += info.Size()
+		}
+	}
+	return store, nil
+}
+
+// Add writes the record to a new file of the store. It returns ErrFull when
+// the record would exceed the maximum size of the store.
+func (s *Store) Add(record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	size := int64(len(data))
+	s.lock.Lock()
+	if s.maxSize > 0 && s.size+size > s.maxSize {
+		s.lock.Unlock()
+		return ErrFull
+	}
+	s.size += size
+	s.lock.Unlock()
+
+	name := fmt.Sprintf("%d-%08d%s", record.Time.UnixNano(), atomic.AddUint64(&s.seq, 1), recordExt)
+	path := filepath.Join(s.dir, name)
+	// Write to a temporary file first so that a crash never leaves a partial record
+	err = os.WriteFile(path+".tmp", data, 0600)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		s.grow(-size)
+	}
+	return err
+}
+
+// Size returns the bytes of the records of the store
+func (s *Store) Size() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.size
+}
+
+func (s *Store) grow(size int64) {
+	s.lock.Lock()
+	s.size += size
+	s.lock.Unlock()
+}
+
+// List returns the paths of the records, oldest first
+func (s *Store) List() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*"+recordExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (s *Store) Load(path string) (*Record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	record := &Record{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("failed to parse dead-letter record %s: %s", path, err)
+	}
+	return record, nil
+}
+
+func (s *Store) Remove(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	s.grow(-info.Size())
+	return nil
+}
@@ -0,0 +1,13 @@
+package deadletter_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestDeadletter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Deadletter Suite")
+}
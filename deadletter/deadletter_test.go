@@ -0,0 +1,125 @@
+package deadletter_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	. "github.com/cloudfoundry-community/splunk-firehose-nozzle/deadletter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Deadletter", func() {
+	events := []map[string]interface{}{{"event": "hello"}}
+
+	Context("NewRecord", func() {
+		It("records the HEC status code and response", func() {
+			err := fmt.Errorf("batch failed: %w", &eventwriter.HECError{StatusCode: 503, Body: "busy"})
+			record := NewRecord(events, err)
+			Expect(record.ErrorType).To(Equal("http_503"))
+			Expect(record.StatusCode).To(Equal(503))
+			Expect(record.Response).To(Equal("busy"))
+			Expect(record.Events).To(Equal(events))
+		})
+
+		It("records network errors", func() {
+			record := NewRecord(events, errors.New("connection refused"))
+			Expect(record.ErrorType).To(Equal(ErrorTypeNetwork))
+			Expect(record.Error).To(Equal("connection refused"))
+			Expect(record.StatusCode).To(BeZero())
+		})
+	})
+
+	Context("Filter", func() {
+		now := time.Now()
+		record := &Record{Time: now, ErrorType: "http_503"}
+
+		It("matches everything without bounds", func() {
+			Expect((&Filter{}).Matches(record)).To(BeTrue())
+		})
+
+		It("matches the time range", func() {
+			Expect((&Filter{From: now.Add(-time.Minute), To: now.Add(time.Minute)}).Matches(record)).To(BeTrue())
+			Expect((&Filter{From: now.Add(time.Second)}).Matches(record)).To(BeFalse())
+			Expect((&Filter{To: now}).Matches(record)).To(BeFalse())
+		})
+
+		It("matches error types and classes", func() {
+			Expect((&Filter{ErrorTypes: []string{"http_503"}}).Matches(record)).To(BeTrue())
+			Expect((&Filter{ErrorTypes: []string{"network", "http_5xx"}}).Matches(record)).To(BeTrue())
+			Expect((&Filter{ErrorTypes: []string{"http_4xx"}}).Matches(record)).To(BeFalse())
+			Expect((&Filter{ErrorTypes: []string{"network"}}).Matches(record)).To(BeFalse())
+		})
+	})
+
+	Context("Store", func() {
+		var (
+			dir   string
+			store *Store
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = os.MkdirTemp("", "deadletter")
+			Ω(err).ShouldNot(HaveOccurred())
+			store, err = NewStore(dir, 0)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("adds, lists, loads and removes records", func() {
+			first := NewRecord(events, errors.New("first"))
+			second := NewRecord(events, &eventwriter.HECError{StatusCode: 400, Body: "bad"})
+			second.Tenant = "org-a"
+			Ω(store.Add(first)).ShouldNot(HaveOccurred())
+			Ω(store.Add(second)).ShouldNot(HaveOccurred())
+
+			paths, err := store.List()
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(paths).To(HaveLen(2))
+
+			loaded, err := store.Load(paths[1])
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(loaded.Tenant).To(Equal("org-a"))
+			Expect(loaded.ErrorType).To(Equal("http_400"))
+			Expect(loaded.Events).To(Equal(events))
+
+			Ω(store.Remove(paths[0])).ShouldNot(HaveOccurred())
+			paths, err = store.List()
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(paths).To(HaveLen(1))
+		})
+
+		It("rejects records over the maximum size", func() {
+			record := NewRecord(events, errors.New("connection refused"))
+			data, err := json.Marshal(record)
+			Ω(err).ShouldNot(HaveOccurred())
+			size := int64(len(data))
+
+			store, err = NewStore(dir, 2*size)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(store.Add(record)).ShouldNot(HaveOccurred())
+			Ω(store.Add(record)).ShouldNot(HaveOccurred())
+			Expect(store.Add(record)).To(Equal(ErrFull))
+			Expect(store.Size()).To(Equal(2 * size))
+
+			paths, _ := store.List()
+			Ω(store.Remove(paths[0])).ShouldNot(HaveOccurred())
+			Ω(store.Add(record)).ShouldNot(HaveOccurred())
+
+			// The size of the existing records counts on reopening
+			store, err = NewStore(dir, 2*size)
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(store.Size()).To(Equal(2 * size))
+			Expect(store.Add(record)).To(Equal(ErrFull))
+		})
+	})
+})
@@ -0,0 +1,594 @@
+package diskqueue
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// Sync policies of the queue files
+const (
+	SyncAlways   = "always"   // fsync every record and persist the cursor on every commit
+	SyncInterval = "interval" // fsync and persist the cursor every sync interval
+	SyncNever    = "never"    // leave it to the OS, the cursor is persisted when segments roll and on close
+)
+
+const (
+	segmentExt = ".seg"
+	cursorFile = "cursor"
+	// record header: payload length, payload CRC32 and enqueue time in nanoseconds
+	headerSize = 4 + 4 + 8
+)
+
+var (
+	ErrFull   = errors.New("disk queue is full")
+	ErrClosed = errors.New("disk queue is closed")
+)
+
+type Config struct {
+	Dir          string
+	SegmentSize  int64 // bytes per segment file
+	MaxSize      int64 // bytes on disk, 0 means unlimited
+	SyncPolicy   string
+	SyncInterval time.Duration
+	Logger       lager.Logger
+}
+
+type segment struct {
+	seq  uint64
+	size int64
+	file *os.File
+}
+
+// position is an offset in a segment
+type position struct {
+	seq    uint64
+	offset int64
+}
+
+// Queue is a FIFO of records persisted in segment files. Records are pushed
+// to the last segment and read from the first one, which is deleted once
+// fully acknowledged. A single consumer peeks the next record and either
+// commits it, or advances past it and acknowledges it later with Ack, e.g.
+// once it has been processed. Records which are not acknowledged before a
+// restart are read again.
+type Queue struct {
+	config *Config
+
+	mutex     sync.Mutex
+	segments  []*segment // oldest first, the last one is written
+	ackOffset int64      // offset of the oldest record not acknowledged in the first segment
+	read      position   // position of the next record to peek
+	peekSize  int64      // size of the peeked record, 0 when none
+	pending   []position // ends of the records read and not acknowledged yet, oldest first
+	size      int64      // bytes on disk
+	dirty     bool       // unsynced writes or acknowledgements
+	closed    bool
+
+	notify  chan struct{}
+	closing chan struct{}
+	wg      sync.WaitGroup
+}
+
+// Open opens the queue in config.Dir, recovering the records left by a
+// previous run. A partially written record at the end of the queue, e.g. after
+// a crash, is truncated.
+func Open(config *Config) (*Queue, error) {
+	switch config.SyncPolicy {
+	case SyncAlways, SyncInterval, SyncNever:
+	default:
+		return nil, fmt.Errorf("invalid disk queue sync policy [%s]", config.SyncPolicy)
+	}
+	if config.SegmentSize <= 0 {
+		return nil, fmt.Errorf("invalid disk queue segment size [%d]", config.SegmentSize)
+	}
+	if err := os.MkdirAll(config.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create disk queue directory: %s", err)
+	}
+
+	q := &Queue{
+		config:  config,
+		notify:  make(chan struct{}, 1),
+		closing: make(chan struct{}),
+	}
+	if err := q.recover(); err != nil {
+		q.closeSegments()
+		return nil, err
+	}
+
+	if config.SyncPolicy == SyncInterval && config.SyncInterval > 0 {
+		q.wg.Add(1)
+		go q.syncLoop()
+	}
+	return q, nil
+}
+
+// Push appends a record to the queue. It returns ErrFull when the record
+// would exceed the maximum size of the queue.
+func (q *Queue) Push(data []byte) error {
+	record := make([]byte, headerSize+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(data))
+	binary.BigEndian.PutUint64(record[8:16], uint64(time.Now().UnixNano()))
+	copy(record[headerSize:], data)
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return ErrClosed
+	}
+	if q.config.MaxSize > 0 && q.size+int64(len(record)) > q.config.MaxSize {
+		if err := q.reclaim(); err != nil {
+			return err
+		}
+		if q.size+int64(len(record)) > q.config.MaxSize {
+			return ErrFull
+		}
+	}
+
+	tail := q.segments[len(q.segments)-1]
+	if tail.size > 0 && tail.size+int64(len(record)) > q.config.SegmentSize {
+		if err := tail.file.Sync(); err != nil {
+			return err
+		}
+		var err error
+		if tail, err = q.createSegment(tail.seq + 1); err != nil {
+			return err
+		}
+		q.segments = append(q.segments, tail)
+	}
+
+	if _, err := tail.file.WriteAt(record, tail.size); err != nil {
+		return err
+	}
+	tail.size += int64(len(record))
+	q.size += int64(len(record))
+	q.dirty = true
+
+	if q.config.SyncPolicy == SyncAlways {
+		if err := tail.file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Peek returns the next record, waiting for one until done is closed or the
+// queue is closed. Peeking again without Commit returns the same record.
+func (q *Queue) Peek(done <-chan struct{}) ([]byte, bool) {
+	for {
+		q.mutex.Lock()
+		if q.closed {
+			q.mutex.Unlock()
+			return nil, false
+		}
+		data, err := q.peek()
+		q.mutex.Unlock()
+
+		if err != nil {
+			q.config.Logger.Error("Failed to read disk queue, skipping the rest of the segment", err)
+			continue
+		}
+		if data != nil {
+			return data, true
+		}
+
+		select {
+		case <-q.notify:
+		case <-done:
+			return nil, false
+		case <-q.closing:
+			return nil, false
+		}
+	}
+}
+
+// Commit removes the peeked record, and the records read before it which
+// were not acknowledged yet, from the queue
+func (q *Queue) Commit() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.peekSize == 0 {
+		return nil
+	}
+	q.advance()
+	return q.ack(len(q.pending))
+}
+
+// Advance moves past the peeked record. The record stays in the queue, and is
+// read again after a restart, until it is acknowledged with Ack.
+func (q *Queue) Advance() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.peekSize == 0 {
+		return
+	}
+	q.advance()
+}
+
+// Ack removes the n oldest records read with Advance from the queue
+func (q *Queue) Ack(n int) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if n > len(q.pending) {
+		n = len(q.pending)
+	}
+	if n <= 0 || q.closed {
+		return nil
+	}
+	return q.ack(n)
+}
+
+func (q *Queue) advance() {
+	q.read.offset += q.peekSize
+	q.peekSize = 0
+	q.pending = append(q.pending, q.read)
+}
+
+// ack moves the acknowledged offset to the end of the n-th pending record,
+// deleting the segments fully acknowledged
+func (q *Queue) ack(n int) error {
+	end := q.pending[n-1]
+	q.pending = q.pending[n:]
+	q.dirty = true
+
+	for q.segments[0].seq < end.seq {
+		if err := q.dropHead(); err != nil {
+			return err
+		}
+	}
+	q.ackOffset = end.offset
+
+	if q.ackOffset >= q.segments[0].size && len(q.segments) > 1 {
+		return q.dropHead()
+	}
+	if q.config.SyncPolicy == SyncAlways {
+		return q.writeCursor()
+	}
+	return nil
+}
+
+// Size returns the bytes used on disk by the queue
+func (q *Queue) Size() int64 {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.size
+}
+
+// OldestAge returns for how long the oldest record has been queued, 0 when
+// the queue is empty
+func (q *Queue) OldestAge() time.Duration {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return 0
+	}
+	head := q.segments[0]
+	if q.ackOffset+headerSize > head.size {
+		if len(q.segments) == 1 {
+			return 0
+		}
+		head = q.segments[1]
+		if head.size < headerSize {
+			return 0
+		}
+		return q.ageAt(head, 0)
+	}
+	return q.ageAt(head, q.ackOffset)
+}
+
+// Close syncs the queue and persists the cursor. Records which were not
+// committed are read again when the queue is reopened.
+func (q *Queue) Close() error {
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return nil
+	}
+	q.closed = true
+	close(q.closing)
+	q.mutex.Unlock()
+
+	q.wg.Wait()
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	err := q.sync()
+	q.closeSegments()
+	return err
+}
+
+// peek reads the record at the read position, moving to the next segment
+// when the segment is fully read. It returns nil without error when the queue
+// is empty.
+func (q *Queue) peek() ([]byte, error) {
+	for {
+		i := q.segmentIndex(q.read.seq)
+		s := q.segments[i]
+		if q.read.offset >= s.size {
+			if i == len(q.segments)-1 {
+				return nil, nil
+			}
+			q.read = position{seq: q.segments[i+1].seq}
+			continue
+		}
+
+		data, err := readRecord(s.file, q.read.offset, s.size)
+		if err != nil {
+			// Corrupted record, give up the rest of the segment
+			q.read.offset = s.size
+			q.peekSize = 0
+			return nil, err
+		}
+		q.peekSize = int64(headerSize + len(data))
+		return data, nil
+	}
+}
+
+// segmentIndex returns the index of the segment seq, or of the first segment
+// when it has been deleted
+func (q *Queue) segmentIndex(seq uint64) int {
+	for i, s := range q.segments {
+		if s.seq == seq {
+			return i
+		}
+	}
+	q.read = position{seq: q.segments[0].seq, offset: q.ackOffset}
+	return 0
+}
+
+// dropHead deletes the first segment once it has been fully acknowledged
+func (q *Queue) dropHead() error {
+	head := q.segments[0]
+	q.segments = q.segments[1:]
+	q.ackOffset = 0
+	if q.read.seq == head.seq {
+		q.read = position{seq: q.segments[0].seq}
+		q.peekSize = 0
+	}
+	q.size -= head.size
+
+	head.file.Close()
+	if err := os.Remove(head.file.Name()); err != nil {
+		return err
+	}
+	return q.writeCursor()
+}
+
+// reclaim frees the space of the records already acknowledged
+func (q *Queue) reclaim() error {
+	for len(q.segments) > 1 && q.ackOffset >= q.segments[0].size {
+		if err := q.dropHead(); err != nil {
+			return err
+		}
+	}
+
+	head := q.segments[0]
+	if len(q.segments) == 1 && head.size > 0 && q.ackOffset >= head.size {
+		if err := head.file.Truncate(0); err != nil {
+			return err
+		}
+		q.size -= head.size
+		head.size = 0
+		q.ackOffset = 0
+		q.read.offset = 0
+		return q.writeCursor()
+	}
+	return nil
+}
+
+func (q *Queue) ageAt(s *segment, offset int64) time.Duration {
+	var header [headerSize]byte
+	if _, err := s.file.ReadAt(header[:], offset); err != nil {
+		return 0
+	}
+	enqueued := time.Unix(0, int64(binary.BigEndian.Uint64(header[8:16])))
+	return time.Since(enqueued)
+}
+
+func (q *Queue) syncLoop() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(q.config.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			q.mutex.Lock()
+			if err := q.sync(); err != nil {
+				q.config.Logger.Error("Failed to sync disk queue", err)
+			}
+			q.mutex.Unlock()
+		case <-q.closing:
+			return
+		}
+	}
+}
+
+// sync fsyncs the written segment and persists the cursor
+func (q *Queue) sync() error {
+	if !q.dirty {
+		return nil
+	}
+	if err := q.segments[len(q.segments)-1].file.Sync(); err != nil {
+		return err
+	}
+	if err := q.writeCursor(); err != nil {
+		return err
+	}
+	q.dirty = false
+	return nil
+}
+
+// writeCursor persists the sequence of the first segment and the offset of
+// the oldest record not acknowledged
+func (q *Queue) writeCursor() error {
+	path := filepath.Join(q.config.Dir, cursorFile)
+	tmp := path + ".tmp"
+	content := fmt.Sprintf("%d %d\n", q.segments[0].seq, q.ackOffset)
+	if err := os.WriteFile(tmp, []byte(content), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (q *Queue) readCursor() (uint64, int64) {
+	content, err := os.ReadFile(filepath.Join(q.config.Dir, cursorFile))
+	if err != nil {
+		return 0, 0
+	}
+	var seq uint64
+	var offset int64
+	if _, err := fmt.Sscanf(string(content), "%d %d", &seq, &offset); err != nil {
+		q.config.Logger.Error("Ignoring invalid disk queue cursor", err)
+		return 0, 0
+	}
+	return seq, offset
+}
+
+func (q *Queue) recover() error {
+	entries, err := os.ReadDir(q.config.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to read disk queue directory: %s", err)
+	}
+	var seqs []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	cursorSeq, cursorOffset := q.readCursor()
+	for _, seq := range seqs {
+		path := q.segmentPath(seq)
+		if seq < cursorSeq {
+			// Fully read before the last run stopped
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			continue
+		}
+		file, err := os.OpenFile(path, os.O_RDWR, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open disk queue segment: %s", err)
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return err
+		}
+		q.segments = append(q.segments, &segment{seq: seq, size: info.Size(), file: file})
+		q.size += info.Size()
+	}
+
+	if len(q.segments) == 0 {
+		s, err := q.createSegment(cursorSeq)
+		if err != nil {
+			return err
+		}
+		q.segments = append(q.segments, s)
+	} else if q.segments[0].seq == cursorSeq && cursorOffset <= q.segments[0].size {
+		q.ackOffset = cursorOffset
+	}
+	q.read = position{seq: q.segments[0].seq, offset: q.ackOffset}
+
+	return q.truncateTail()
+}
+
+// truncateTail drops the partially written record at the end of the last
+// segment, if any
+func (q *Queue) truncateTail() error {
+	tail := q.segments[len(q.segments)-1]
+	offset := int64(0)
+	if len(q.segments) == 1 {
+		offset = q.ackOffset
+	}
+	for offset < tail.size {
+		data, err := readRecord(tail.file, offset, tail.size)
+		if err != nil {
+			break
+		}
+		offset += int64(headerSize + len(data))
+	}
+	if offset == tail.size {
+		return nil
+	}
+
+	q.config.Logger.Info(fmt.Sprintf("Truncating %d bytes of partially written records in disk queue", tail.size-offset))
+	if err := tail.file.Truncate(offset); err != nil {
+		return err
+	}
+	q.size -= tail.size - offset
+	tail.size = offset
+	return nil
+}
+
+func (q *Queue) createSegment(seq uint64) (*segment, error) {
+	file, err := os.OpenFile(q.segmentPath(seq), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create disk queue segment: %s", err)
+	}
+	return &segment{seq: seq, file: file}, nil
+}
+
+func (q *Queue) segmentPath(seq uint64) string {
+	return filepath.Join(q.config.Dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+func (q *Queue) closeSegments() {
+	for _, s := range q.segments {
+		s.file.Close()
+	}
+}
+
+// readRecord reads and checks the record at offset of a file of the given size
+func readRecord(file *os.File, offset, size int64) ([]byte, error) {
+	if offset+headerSize > size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	var header [headerSize]byte
+	if _, err := file.ReadAt(header[:], offset); err != nil {
+		return nil, err
+	}
+	length := int64(binary.BigEndian.Uint32(header[0:4]))
+	if offset+headerSize+length > size {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	data := make([]byte, length)
+	if _, err := file.ReadAt(data, offset+headerSize); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, fmt.Errorf("corrupted record at offset %d of %s", offset, file.Name())
+	}
+	return data, nil
+}
@@ -0,0 +1,13 @@
+package diskqueue_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestDiskqueue(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Diskqueue Suite")
+}
@@ -0,0 +1,198 @@
+package diskqueue_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/diskqueue"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Queue", func() {
+	var (
+		dir    string
+		config *diskqueue.Config
+		queue  *diskqueue.Queue
+		done   chan struct{}
+	)
+
+	// pop peeks and commits the next record
+	pop := func(q *diskqueue.Queue) string {
+		data, ok := q.Peek(done)
+		Expect(ok).To(BeTrue())
+		Ω(q.Commit()).ShouldNot(HaveOccurred())
+		return string(data)
+	}
+
+	segments := func() []string {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+		return files
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "diskqueue")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		done = make(chan struct{})
+		config = &diskqueue.Config{
+			Dir:         dir,
+			SegmentSize: 64,
+			SyncPolicy:  diskqueue.SyncNever,
+			Logger:      lager.NewLogger("test"),
+		}
+		queue, err = diskqueue.Open(config)
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		queue.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("reads records in order across segments", func() {
+		for i := 0; i < 10; i++ {
+			Ω(queue.Push([]byte(fmt.Sprintf("record-%d", i)))).ShouldNot(HaveOccurred())
+		}
+		Expect(len(segments())).To(BeNumerically(">", 1))
+
+		for i := 0; i < 10; i++ {
+			Expect(pop(queue)).To(Equal(fmt.Sprintf("record-%d", i)))
+		}
+		Expect(segments()).To(HaveLen(1))
+	})
+
+	It("returns the same record until it is committed", func() {
+		queue.Push([]byte("first"))
+		queue.Push([]byte("second"))
+
+		data, _ := queue.Peek(done)
+		Expect(string(data)).To(Equal("first"))
+		data, _ = queue.Peek(done)
+		Expect(string(data)).To(Equal("first"))
+		queue.Commit()
+		Expect(pop(queue)).To(Equal("second"))
+	})
+
+	It("waits for records until done", func() {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			queue.Push([]byte("late"))
+		}()
+		Expect(pop(queue)).To(Equal("late"))
+
+		close(done)
+		_, ok := queue.Peek(done)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("keeps uncommitted records across restarts", func() {
+		for i := 0; i < 10; i++ {
+			queue.Push([]byte(fmt.Sprintf("record-%d", i)))
+		}
+		pop(queue)
+		pop(queue)
+		queue.Peek(done)
+		Ω(queue.Close()).ShouldNot(HaveOccurred())
+
+		var err error
+		queue, err = diskqueue.Open(config)
+		Ω(err).ShouldNot(HaveOccurred())
+		for i := 2; i < 10; i++ {
+			Expect(pop(queue)).To(Equal(fmt.Sprintf("record-%d", i)))
+		}
+	})
+
+	It("keeps records read but not acknowledged across restarts", func() {
+		for i := 0; i < 10; i++ {
+			queue.Push([]byte(fmt.Sprintf("record-%d", i)))
+		}
+		for i := 0; i < 4; i++ {
+			data, ok := queue.Peek(done)
+			Expect(ok).To(BeTrue())
+			Expect(string(data)).To(Equal(fmt.Sprintf("record-%d", i)))
+			queue.Advance()
+		}
+		Ω(queue.Ack(3)).ShouldNot(HaveOccurred())
+		Ω(queue.Close()).ShouldNot(HaveOccurred())
+
+		var err error
+		queue, err = diskqueue.Open(config)
+		Ω(err).ShouldNot(HaveOccurred())
+		for i := 3; i < 10; i++ {
+			Expect(pop(queue)).To(Equal(fmt.Sprintf("record-%d", i)))
+		}
+		Expect(segments()).To(HaveLen(1))
+	})
+
+	It("truncates partially written records", func() {
+		queue.Push([]byte("complete"))
+		queue.Close()
+
+		files := segments()
+		f, _ := os.OpenFile(files[len(files)-1], os.O_WRONLY|os.O_APPEND, 0600)
+		f.Write([]byte{0, 0, 0, 42, 1, 2})
+		f.Close()
+
+		var err error
+		queue, err = diskqueue.Open(config)
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(pop(queue)).To(Equal("complete"))
+		queue.Push([]byte("after crash"))
+		Expect(pop(queue)).To(Equal("after crash"))
+	})
+
+	It("skips corrupted records", func() {
+		queue.Push([]byte("corrupted"))
+		queue.Push([]byte("lost"))
+		queue.Close()
+
+		f, _ := os.OpenFile(segments()[0], os.O_WRONLY, 0600)
+		f.WriteAt([]byte("x"), 16)
+		f.Close()
+
+		var err error
+		queue, err = diskqueue.Open(config)
+		Ω(err).ShouldNot(HaveOccurred())
+		queue.Push([]byte(fmt.Sprintf("%060d", 0)))
+		Expect(pop(queue)).To(Equal(fmt.Sprintf("%060d", 0)))
+	})
+
+	It("rejects records beyond the maximum size", func() {
+		queue.Close()
+		config.MaxSize = 120
+		var err error
+		queue, err = diskqueue.Open(config)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(queue.Push(make([]byte, 40))).ShouldNot(HaveOccurred())
+		Ω(queue.Push(make([]byte, 40))).ShouldNot(HaveOccurred())
+		Expect(queue.Push(make([]byte, 40))).To(Equal(diskqueue.ErrFull))
+
+		pop(queue)
+		pop(queue)
+		Ω(queue.Push(make([]byte, 40))).ShouldNot(HaveOccurred())
+	})
+
+	It("reports the size and the age of the oldest record", func() {
+		Expect(queue.Size()).To(Equal(int64(0)))
+		Expect(queue.OldestAge()).To(Equal(time.Duration(0)))
+
+		queue.Push([]byte("record"))
+		time.Sleep(20 * time.Millisecond)
+		Expect(queue.Size()).To(Equal(int64(16 + 6)))
+		Expect(queue.OldestAge()).To(BeNumerically(">=", 20*time.Millisecond))
+
+		pop(queue)
+		Expect(queue.OldestAge()).To(Equal(time.Duration(0)))
+	})
+
+	It("rejects invalid sync policies", func() {
+		_, err := diskqueue.Open(&diskqueue.Config{Dir: dir, SegmentSize: 64, SyncPolicy: "sometimes"})
+		Ω(err).Should(HaveOccurred())
+	})
+})
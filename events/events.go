@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
@@ -27,6 +28,9 @@ type Config struct {
 	AddSpaceName   bool
 	AddSpaceGuid   bool
 	AddTags        bool
+
+	CPUEntitlementPerGB float64 // share of a CPU core, in percent, apps are entitled to per GB of memory quota
+	QuotaThreshold      float64 // memory and disk usage within this percentage of quota is flagged, 0 disables the flags
 }
 
 var AppMetadata = []string{
@@ -225,6 +229,7 @@ func (e *Event) parseAndAnnotateWithAppInfo(appInfo *cache.App, config *Config)
 	cfOrgName := appInfo.OrgName
 	cfIgnoredApp := appInfo.IgnoredApp
 	appEnv := appInfo.CfAppEnv
+	appMetadata := appInfo.Metadata
 
 	if cfAppName != "" && config.AddAppName {
 		e.Fields["cf_app_name"] = cfAppName
@@ -246,15 +251,91 @@ func (e *Event) parseAndAnnotateWithAppInfo(appInfo *cache.App, config *Config)
 		e.Fields["cf_org_name"] = cfOrgName
 	}
 
-	if appEnv["SPLUNK_INDEX"] != nil {
+	if appMetadata[cache.MetadataIndex] != "" {
+		e.Fields["info_splunk_index"] = appMetadata[cache.MetadataIndex]
+	} else if appEnv["SPLUNK_INDEX"] != nil {
 		e.Fields["info_splunk_index"] = appEnv["SPLUNK_INDEX"]
 	}
 
+	if appMetadata[cache.MetadataSourcetype] != "" {
+		e.Fields["info_splunk_sourcetype"] = appMetadata[cache.MetadataSourcetype]
+	} else if appEnv["SPLUNK_SOURCETYPE"] != nil {
+		e.Fields["info_splunk_sourcetype"] = appEnv["SPLUNK_SOURCETYPE"]
+	}
+
+	if appEnv["SPLUNK_SOURCE"] != nil {
+		e.Fields["info_splunk_source"] = appEnv["SPLUNK_SOURCE"]
+	}
+
+	if appEnv["SPLUNK_HOST"] != nil {
+		e.Fields["info_splunk_host"] = appEnv["SPLUNK_HOST"]
+	}
+
+	if appMetadata[cache.MetadataTenant] != "" {
+		e.Fields["info_splunk_tenant"] = appMetadata[cache.MetadataTenant]
+	}
+
+	if rate, err := strconv.ParseFloat(appMetadata[cache.MetadataSamplingRate], 64); err == nil && rate >= 0 && rate < 1 {
+		e.Fields["info_splunk_sampling_rate"] = rate
+	}
+
 	if cfIgnoredApp {
 		e.Fields["cf_ignored_app"] = cfIgnoredApp
 	}
 }
 
+// AnnotateWithServiceBindings adds the service instances bound to the
+// event's app as cf_service_instances
+func (e *Event) AnnotateWithServiceBindings(serviceBindings *cache.ServiceBindings) {
+	appGuid, ok := e.Fields["cf_app_id"].(string)
+	if !ok || appGuid == "" {
+		return
+	}
+
+	// Failed refreshes are logged by the cache, the event is left as is
+	instances, err := serviceBindings.GetServiceInstances(appGuid)
+	if err != nil || len(instances) == 0 {
+		return
+	}
+
+	serviceInstances := make([]map[string]string, 0, len(instances))
+	for _, instance := range instances {
+		serviceInstances = append(serviceInstances, map[string]string{
+			"guid":     instance.Guid,
+			"name":     instance.Name,
+			"offering": instance.Offering,
+			"plan":     instance.Plan,
+		})
+	}
+	e.Fields["cf_service_instances"] = serviceInstances
+}
+
+// AnnotateWithContainerUsage adds the memory and disk usage percentages of
+// ContainerMetric events and the fields derived from the configured CPU
+// entitlement and quota threshold
+func (e *Event) AnnotateWithContainerUsage(config *Config) {
+	if config.CPUEntitlementPerGB > 0 {
+		quota, _ := e.Fields["memory_bytes_quota"].(uint64)
+		cpu, _ := e.Fields["cpu_percentage"].(float64)
+		if quota > 0 {
+			entitlement := float64(quota) / (1 << 30) * config.CPUEntitlementPerGB
+			e.Fields["cpu_entitlement_percentage"] = cpu / entitlement * 100
+		}
+	}
+
+	for _, usage := range []string{"memory", "disk"} {
+		bytes, _ := e.Fields[usage+"_bytes"].(uint64)
+		quota, _ := e.Fields[usage+"_bytes_quota"].(uint64)
+		if quota > 0 {
+			percentage := float64(bytes) / float64(quota) * 100
+			e.Fields[usage+"_percentage"] = percentage
+			if config.QuotaThreshold > 0 {
+				e.Fields[usage+"_near_quota"] = percentage >= 100-config.QuotaThreshold
+			}
+		}
+	}
+}
+
 func (e *Event) AnnotateWithCFMetaData() {
 	e.Fields["event_type"] = e.Type
 }
@@ -334,6 +415,51 @@ func ParseExtraFields(extraEventsString string) (map[string]string, error) {
 	return extraEvents, nil
 }
 
+// IndexedField promotes an event field into the HEC indexed fields
+type IndexedField struct {
+	Field string // event field
+	Name  string // indexed field name
+	Move  bool   // remove the field from the event
+}
+
+// ParseIndexedFields parses a comma separated list of field[:name][:copy|move]
+// mappings, for example "cf_app_name,cf_org_name:org,status_code::move"
+func ParseIndexedFields(indexedFieldsString string) ([]IndexedField, error) {
+	var indexedFields []IndexedField
+
+	for _, mapping := range strings.Split(indexedFieldsString, ",") {
+		mapping = strings.TrimSpace(mapping)
+		if mapping == "" {
+			continue
+		}
+
+		values := strings.Split(mapping, ":")
+		if len(values) > 3 {
+			return nil, fmt.Errorf("When splitting %s by ':' there must be at most 3 values, got these values %s", mapping, values)
+		}
+
+		indexedField := IndexedField{Field: strings.TrimSpace(values[0])}
+		if indexedField.Field == "" {
+			return nil, fmt.Errorf("Missing field name in indexed field mapping %s", mapping)
+		}
+		indexedField.Name = indexedField.Field
+		if len(values) > 1 && strings.TrimSpace(values[1]) != "" {
+			indexedField.Name = strings.TrimSpace(values[1])
+		}
+		if len(values) > 2 {
+			switch strings.TrimSpace(values[2]) {
+			case "", "copy":
+			case "move":
+				indexedField.Move = true
+			default:
+				return nil, fmt.Errorf("Invalid mode %s in indexed field mapping %s, valid modes: copy, move", values[2], mapping)
+			}
+		}
+		indexedFields = append(indexedFields, indexedField)
+	}
+	return indexedFields, nil
+}
+
 func AuthorizedMetadata() string {
 	return strings.Join(AppMetadata, ", ")
 }
@@ -2,7 +2,9 @@ package events_test
 
 import (
 	"math"
+	"time"
 
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
 	fevents "github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
 	. "github.com/cloudfoundry/sonde-go/events"
@@ -163,6 +165,28 @@ var _ = Describe("Events", func() {
 		Expect(evt.Fields["memory_bytes"]).To(Equal(memoryBytes))
 		Expect(evt.Fields["memory_bytes_quota"]).To(Equal(memoryBytesQuota))
 		Expect(evt.Fields["instance_index"]).To(Equal(instanceIdx))
+		Expect(evt.Fields).NotTo(HaveKey("memory_percentage"))
+		Expect(evt.Fields).NotTo(HaveKey("disk_percentage"))
+	})
+
+	It("ContainerMetric usage", func() {
+		msg = NewContainerMetric()
+		evt := fevents.ContainerMetric(msg)
+		evt.AnnotateWithContainerUsage(&fevents.Config{})
+		Expect(evt.Fields).NotTo(HaveKey("cpu_entitlement_percentage"))
+		Expect(evt.Fields).NotTo(HaveKey("memory_near_quota"))
+		Expect(evt.Fields).NotTo(HaveKey("disk_near_quota"))
+		Expect(evt.Fields["memory_percentage"]).To(Equal(10.0))
+		Expect(evt.Fields["disk_percentage"]).To(Equal(10.0))
+
+		evt.Fields["memory_bytes_quota"] = uint64(512 << 20)
+		evt.Fields["disk_bytes"] = uint64(95)
+		evt.Fields["disk_bytes_quota"] = uint64(100)
+		evt.AnnotateWithContainerUsage(&fevents.Config{CPUEntitlementPerGB: 40, QuotaThreshold: 10})
+		Expect(evt.Fields["cpu_entitlement_percentage"]).To(Equal(50.0))
+		Expect(evt.Fields["disk_percentage"]).To(Equal(95.0))
+		Expect(evt.Fields["memory_near_quota"]).To(BeFalse())
+		Expect(evt.Fields["disk_near_quota"]).To(BeTrue())
 	})
 
 	Context("given a envelope", func() {
@@ -209,6 +233,72 @@ var _ = Describe("Events", func() {
 		})
 	})
 
+	Context("given Application env overrides", func() {
+		It("Should annotate sourcetype, source and host", func() {
+			fcache.SetAppEnv(map[string]interface{}{
+				"SPLUNK_SOURCETYPE": "team:logs",
+				"SPLUNK_SOURCE":     "billing",
+				"SPLUNK_HOST":       "billing.example.com",
+			})
+			event.AnnotateWithAppData(fcache, &fevents.Config{})
+			Expect(event.Fields["info_splunk_sourcetype"]).To(Equal("team:logs"))
+			Expect(event.Fields["info_splunk_source"]).To(Equal("billing"))
+			Expect(event.Fields["info_splunk_host"]).To(Equal("billing.example.com"))
+		})
+	})
+
+	Context("given Application CF Metadata", func() {
+		It("Should route with the resolved metadata", func() {
+			fcache.SetMetadata(map[string]string{
+				cache.MetadataIndex:        "app_index",
+				cache.MetadataSourcetype:   "team:logs",
+				cache.MetadataSamplingRate: "0.25",
+			})
+			event.AnnotateWithAppData(fcache, &fevents.Config{})
+			Expect(event.Fields["info_splunk_index"]).To(Equal("app_index"))
+			Expect(event.Fields["info_splunk_sourcetype"]).To(Equal("team:logs"))
+			Expect(event.Fields["info_splunk_sampling_rate"]).To(Equal(0.25))
+		})
+
+		It("Should prefer metadata over app env", func() {
+			fcache.SetAppEnv(map[string]interface{}{
+				"SPLUNK_INDEX":      "env_index",
+				"SPLUNK_SOURCETYPE": "env:sourcetype",
+			})
+			fcache.SetMetadata(map[string]string{cache.MetadataIndex: "app_index"})
+			event.AnnotateWithAppData(fcache, &fevents.Config{})
+			Expect(event.Fields["info_splunk_index"]).To(Equal("app_index"))
+			Expect(event.Fields["info_splunk_sourcetype"]).To(Equal("env:sourcetype"))
+		})
+
+		It("Should ignore invalid sampling rates", func() {
+			fcache.SetMetadata(map[string]string{cache.MetadataSamplingRate: "not-a-rate"})
+			event.AnnotateWithAppData(fcache, &fevents.Config{})
+			Expect(event.Fields).NotTo(HaveKey("info_splunk_sampling_rate"))
+		})
+	})
+
+	Context("given service bindings", func() {
+		It("Should add the service instances bound to the app", func() {
+			client := testing.NewServiceBindingClientMock()
+			client.CreateServiceInstance("si-1", "orders-db", "postgres", "small")
+			client.Bind("eea38ba5-53a5-4173-9617-b442d35ec2fd", "si-1")
+			serviceBindings := cache.NewServiceBindings(client, &cache.ServiceBindingsConfig{TTL: time.Hour})
+
+			event.AnnotateWithServiceBindings(serviceBindings)
+			Expect(event.Fields["cf_service_instances"]).To(Equal([]map[string]string{
+				{"guid": "si-1", "name": "orders-db", "offering": "postgres", "plan": "small"},
+			}))
+		})
+
+		It("Should add nothing for apps without bindings", func() {
+			serviceBindings := cache.NewServiceBindings(testing.NewServiceBindingClientMock(), &cache.ServiceBindingsConfig{TTL: time.Hour})
+
+			event.AnnotateWithServiceBindings(serviceBindings)
+			Expect(event.Fields).NotTo(HaveKey("cf_service_instances"))
+		})
+	})
+
 	It("HttpStart", func() {
 		var config = &fevents.Config{
 			AddAppName:   true,
@@ -304,4 +394,30 @@ var _ = Describe("Events", func() {
 		})
 	})
 
+	Describe("ParseIndexedFields", func() {
+		It("should return no mappings for an empty string", func() {
+			Expect(fevents.ParseIndexedFields("")).To(BeEmpty())
+		})
+
+		It("should parse copy, rename and move mappings", func() {
+			expected := []fevents.IndexedField{
+				{Field: "cf_app_name", Name: "cf_app_name"},
+				{Field: "cf_org_name", Name: "org"},
+				{Field: "status_code", Name: "status_code", Move: true},
+				{Field: "origin", Name: "source_origin", Move: true},
+			}
+			Expect(fevents.ParseIndexedFields(" cf_app_name, cf_org_name:org,status_code::move,origin:source_origin:move")).To(Equal(expected))
+		})
+
+		It("should return a error on invalid mode", func() {
+			_, err := fevents.ParseIndexedFields("origin:o:swap")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return a error on too many values", func() {
+			_, err := fevents.ParseIndexedFields("a:b:move:c")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
 })
@@ -0,0 +1,135 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+)
+
+const (
+	LogMetricCounter = "counter"
+	LogMetricValue   = "value"
+)
+
+// LogMetricRule extracts a metric datapoint from LogMessage content, either
+// by regex or from a field of JSON messages.
+type LogMetricRule struct {
+	Name      string `json:"name"`       // metric name
+	Type      string `json:"type"`       // counter counts matching messages, value extracts a number
+	Regex     string `json:"regex"`      // regex matched against the message
+	JSONField string `json:"json_field"` // field of JSON messages, instead of regex
+	Group     string `json:"group"`      // capture group holding the value, defaults to "value" or the first group
+
+	regex *regexp.Regexp
+}
+
+type LogMetricRules []*LogMetricRule
+
+// ParseLogMetricRules parses log metric rules from a JSON list or a file containing the list
+func ParseLogMetricRules(rulesString string) (LogMetricRules, error) {
+	if strings.TrimSpace(rulesString) == "" {
+		return nil, nil
+	}
+
+	var rules LogMetricRules
+	if err := utils.UnmarshalJSONOrFile(rulesString, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse log metric rules: %s", err)
+	}
+
+	names := make(map[string]bool)
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("log metric rule %+v must have a name", *rule)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate log metric rule [%s]", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if rule.Type != LogMetricCounter && rule.Type != LogMetricValue {
+			return nil, fmt.Errorf("invalid type [%s] of log metric rule [%s] - valid types: %s, %s", rule.Type, rule.Name, LogMetricCounter, LogMetricValue)
+		}
+		if (rule.Regex == "") == (rule.JSONField == "") {
+			return nil, fmt.Errorf("log metric rule [%s] must have either a regex or a json_field", rule.Name)
+		}
+		if rule.Regex == "" {
+			continue
+		}
+
+		regex, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex of log metric rule [%s]: %s", rule.Name, err)
+		}
+		rule.regex = regex
+		if rule.Type == LogMetricValue && rule.valueGroup() < 0 {
+			return nil, fmt.Errorf("regex of log metric rule [%s] has no capture group for the value", rule.Name)
+		}
+	}
+	return rules, nil
+}
+
+// Extract returns the datapoint of the message, 1 for counters.
+// ok is false when the message doesn't match the rule.
+func (r *LogMetricRule) Extract(msg string) (value float64, ok bool) {
+	if r.regex != nil {
+		matches := r.regex.FindStringSubmatch(msg)
+		if matches == nil {
+			return 0, false
+		}
+		if r.Type == LogMetricCounter {
+			return 1, true
+		}
+		return toFloat(matches[r.valueGroup()])
+	}
+
+	// Cheap check before parsing, most messages are not JSON
+	if !strings.HasPrefix(strings.TrimSpace(msg), "{") {
+		return 0, false
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(msg), &fields); err != nil {
+		return 0, false
+	}
+	v, found := fields[r.JSONField]
+	if !found || v == nil {
+		return 0, false
+	}
+	if r.Type == LogMetricCounter {
+		return 1, true
+	}
+	return toFloat(v)
+}
+
+// valueGroup returns the index of the capture group holding the value, -1 if none
+func (r *LogMetricRule) valueGroup() int {
+	group := r.Group
+	if group == "" {
+		group = "value"
+	}
+	if i := r.regex.SubexpIndex(group); i > 0 {
+		return i
+	}
+	if i, err := strconv.Atoi(group); err == nil && i > 0 && i <= r.regex.NumSubexp() {
+		return i
+	}
+	if r.Group == "" && r.regex.NumSubexp() > 0 {
+		return 1
+	}
+	return -1
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch value := v.(type) {
+	case float64:
+		return value, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
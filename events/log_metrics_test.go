@@ -0,0 +1,95 @@
+package events_test
+
+import (
+	fevents "github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LogMetricRules", func() {
+
+	Context("ParseLogMetricRules", func() {
+		It("returns no rules for an empty string", func() {
+			rules, err := fevents.ParseLogMetricRules("")
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(rules).To(BeEmpty())
+		})
+
+		It("rejects invalid types", func() {
+			_, err := fevents.ParseLogMetricRules(`[{"name":"orders","type":"gauge","regex":"processed"}]`)
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("rejects rules with both or none of regex and json_field", func() {
+			_, err := fevents.ParseLogMetricRules(`[{"name":"orders","type":"counter"}]`)
+			Ω(err).Should(HaveOccurred())
+			_, err = fevents.ParseLogMetricRules(`[{"name":"orders","type":"counter","regex":"a","json_field":"b"}]`)
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("rejects invalid regexes", func() {
+			_, err := fevents.ParseLogMetricRules(`[{"name":"orders","type":"counter","regex":"("}]`)
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("rejects value rules without capture group", func() {
+			_, err := fevents.ParseLogMetricRules(`[{"name":"orders","type":"value","regex":"processed"}]`)
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("rejects duplicate names", func() {
+			_, err := fevents.ParseLogMetricRules(`[{"name":"a","type":"counter","regex":"x"},{"name":"a","type":"counter","regex":"y"}]`)
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("Extract", func() {
+		It("counts regex matches", func() {
+			rules, err := fevents.ParseLogMetricRules(`[{"name":"orders","type":"counter","regex":"processed \\d+ orders"}]`)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			value, ok := rules[0].Extract("processed 512 orders in 3.2s")
+			Expect(ok).To(BeTrue())
+			Expect(value).To(Equal(float64(1)))
+
+			_, ok = rules[0].Extract("started")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("extracts values from capture groups", func() {
+			rules, err := fevents.ParseLogMetricRules(`[
+				{"name":"orders","type":"value","regex":"processed (\\d+) orders in ([\\d.]+)s"},
+				{"name":"duration","type":"value","regex":"processed (\\d+) orders in ([\\d.]+)s","group":"2"},
+				{"name":"named","type":"value","regex":"in (?P<value>[\\d.]+)s"}
+			]`)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			value, ok := rules[0].Extract("processed 512 orders in 3.2s")
+			Expect(ok).To(BeTrue())
+			Expect(value).To(Equal(float64(512)))
+
+			value, ok = rules[1].Extract("processed 512 orders in 3.2s")
+			Expect(ok).To(BeTrue())
+			Expect(value).To(Equal(3.2))
+
+			value, ok = rules[2].Extract("processed 512 orders in 3.2s")
+			Expect(ok).To(BeTrue())
+			Expect(value).To(Equal(3.2))
+		})
+
+		It("extracts values from JSON fields", func() {
+			rules, err := fevents.ParseLogMetricRules(`[{"name":"latency","type":"value","json_field":"latency_ms"}]`)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			value, ok := rules[0].Extract(`{"msg":"done","latency_ms":42.5}`)
+			Expect(ok).To(BeTrue())
+			Expect(value).To(Equal(42.5))
+
+			_, ok = rules[0].Extract(`{"msg":"done"}`)
+			Expect(ok).To(BeFalse())
+
+			_, ok = rules[0].Extract(`latency_ms=42.5`)
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
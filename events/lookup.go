@@ -0,0 +1,171 @@
+package events
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+)
+
+// LookupConfig maps the values of an event field to the fields of a lookup file.
+// CSV files have a header row, Column names the key column and defaults to Field.
+// JSON files hold an object of key to object of fields.
+type LookupConfig struct {
+	File   string `json:"file"`
+	Field  string `json:"field"`
+	Column string `json:"column"`
+}
+
+type lookupTable struct {
+	config  LookupConfig
+	modTime time.Time
+	rows    map[string]map[string]interface{}
+}
+
+// Lookups enriches events with the fields of lookup files, which are
+// reloaded when they change on disk
+type Lookups struct {
+	mutex  sync.RWMutex
+	tables []*lookupTable
+}
+
+// NewLookups parses lookup configs from a JSON list or a file containing the
+// list, and loads the lookup files. It returns nil when there is no lookup.
+func NewLookups(lookupsString string) (*Lookups, error) {
+	if strings.TrimSpace(lookupsString) == "" {
+		return nil, nil
+	}
+
+	var configs []LookupConfig
+	if err := utils.UnmarshalJSONOrFile(lookupsString, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse lookups: %s", err)
+	}
+
+	lookups := &Lookups{}
+	for _, config := range configs {
+		if config.File == "" || config.Field == "" {
+			return nil, fmt.Errorf("lookup %+v must have a file and a field", config)
+		}
+		if config.Column == "" {
+			config.Column = config.Field
+		}
+		table := &lookupTable{config: config}
+		if err := table.load(); err != nil {
+			return nil, err
+		}
+		lookups.tables = append(lookups.tables, table)
+	}
+	return lookups, nil
+}
+
+// Reload reloads the lookup files which changed on disk. Tables which fail
+// to reload keep their previous content.
+func (l *Lookups) Reload() error {
+	var errs []string
+	for _, table := range l.tables {
+		info, err := os.Stat(table.config.File)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		l.mutex.RLock()
+		changed := !info.ModTime().Equal(table.modTime)
+		l.mutex.RUnlock()
+		if !changed {
+			continue
+		}
+
+		reloaded := &lookupTable{config: table.config}
+		if err := reloaded.load(); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		l.mutex.Lock()
+		*table = *reloaded
+		l.mutex.Unlock()
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to reload lookups: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Annotate merges the lookup fields matching the event into fields. Existing
+// event fields are not overridden.
+func (l *Lookups) Annotate(fields map[string]interface{}) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	for _, table := range l.tables {
+		key, ok := fields[table.config.Field]
+		if !ok || key == nil {
+			continue
+		}
+		for k, v := range table.rows[fmt.Sprint(key)] {
+			if _, exists := fields[k]; !exists {
+				fields[k] = v
+			}
+		}
+	}
+}
+
+func (t *lookupTable) load() error {
+	file, err := os.Open(t.config.File)
+	if err != nil {
+		return fmt.Errorf("failed to open lookup file: %s", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to open lookup file: %s", err)
+	}
+
+	rows := make(map[string]map[string]interface{})
+	if strings.EqualFold(filepath.Ext(t.config.File), ".json") {
+		if err := json.NewDecoder(file).Decode(&rows); err != nil {
+			return fmt.Errorf("failed to parse lookup file %s: %s", t.config.File, err)
+		}
+	} else {
+		records, err := csv.NewReader(file).ReadAll()
+		if err != nil {
+			return fmt.Errorf("failed to parse lookup file %s: %s", t.config.File, err)
+		}
+		if len(records) == 0 {
+			return fmt.Errorf("lookup file %s has no header", t.config.File)
+		}
+
+		header := records[0]
+		keyColumn := -1
+		for i, name := range header {
+			if name == t.config.Column {
+				keyColumn = i
+			}
+		}
+		if keyColumn < 0 {
+			return fmt.Errorf("lookup file %s has no column [%s]", t.config.File, t.config.Column)
+		}
+
+		for _, record := range records[1:] {
+			row := make(map[string]interface{}, len(header)-1)
+			for i, value := range record {
+				if i != keyColumn && value != "" {
+					row[header[i]] = value
+				}
+			}
+			rows[record[keyColumn]] = row
+		}
+	}
+
+	t.rows = rows
+	t.modTime = info.ModTime()
+	return nil
+}
@@ -0,0 +1,88 @@
+package events_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	fevents "github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lookups", func() {
+	var (
+		dir     string
+		csvFile string
+		fields  map[string]interface{}
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "lookups")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		csvFile = filepath.Join(dir, "orgs.csv")
+		os.WriteFile(csvFile, []byte("org_guid,cost_centre,business_unit\norg-1,cc-100,payments\norg-2,cc-200,\n"), 0600)
+		os.WriteFile(filepath.Join(dir, "origins.json"), []byte(`{"rep":{"team":"diego"}}`), 0600)
+
+		fields = map[string]interface{}{"cf_org_id": "org-1", "origin": "rep", "team": "existing"}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("returns nil without lookups", func() {
+		lookups, err := fevents.NewLookups("")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(lookups).To(BeNil())
+	})
+
+	It("rejects missing files and columns", func() {
+		_, err := fevents.NewLookups(`[{"file":"/nonexistent.csv","field":"cf_org_id"}]`)
+		Ω(err).Should(HaveOccurred())
+
+		_, err = fevents.NewLookups(`[{"file":"` + csvFile + `","field":"cf_org_id"}]`)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("merges CSV and JSON lookup fields without overriding event fields", func() {
+		lookups, err := fevents.NewLookups(`[
+			{"file":"` + csvFile + `","field":"cf_org_id","column":"org_guid"},
+			{"file":"` + filepath.Join(dir, "origins.json") + `","field":"origin"}
+		]`)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		lookups.Annotate(fields)
+		Expect(fields["cost_centre"]).To(Equal("cc-100"))
+		Expect(fields["business_unit"]).To(Equal("payments"))
+		Expect(fields["team"]).To(Equal("existing"))
+
+		other := map[string]interface{}{"cf_org_id": "org-2", "origin": "router"}
+		lookups.Annotate(other)
+		Expect(other["cost_centre"]).To(Equal("cc-200"))
+		Expect(other).NotTo(HaveKey("business_unit"))
+		Expect(other).NotTo(HaveKey("team"))
+	})
+
+	It("reloads changed files and keeps the previous content on errors", func() {
+		lookups, err := fevents.NewLookups(`[{"file":"` + csvFile + `","field":"cf_org_id","column":"org_guid"}]`)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		os.WriteFile(csvFile, []byte("org_guid,cost_centre\norg-1,cc-999\n"), 0600)
+		later := time.Now().Add(time.Minute)
+		os.Chtimes(csvFile, later, later)
+		Ω(lookups.Reload()).ShouldNot(HaveOccurred())
+		lookups.Annotate(fields)
+		Expect(fields["cost_centre"]).To(Equal("cc-999"))
+
+		os.WriteFile(csvFile, []byte("other\nx\n"), 0600)
+		later = later.Add(time.Minute)
+		os.Chtimes(csvFile, later, later)
+		Ω(lookups.Reload()).Should(HaveOccurred())
+		fields = map[string]interface{}{"cf_org_id": "org-1"}
+		lookups.Annotate(fields)
+		Expect(fields["cost_centre"]).To(Equal("cc-999"))
+	})
+})
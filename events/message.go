@@ -0,0 +1,57 @@
+package events
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Policies for messages over the maximum size
+const (
+	OversizedTruncate = "truncate"
+	OversizedSplit    = "split"
+	OversizedDrop     = "drop"
+)
+
+// SanitizeMessage replaces invalid UTF-8 sequences with U+FFFD and strips
+// control characters other than tab, newline and carriage return
+func SanitizeMessage(msg string) string {
+	msg = strings.ToValidUTF8(msg, "�")
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r' {
+			return -1
+		}
+		return r
+	}, msg)
+}
+
+// SplitMessage splits msg in parts of at most max bytes without splitting
+// UTF-8 characters
+func SplitMessage(msg string, max int) []string {
+	if max <= 0 || len(msg) <= max {
+		return []string{msg}
+	}
+
+	var parts []string
+	for len(msg) > max {
+		cut := max
+		for cut > 0 && !utf8.RuneStart(msg[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			// max is smaller than a character
+			cut = max
+		}
+		parts = append(parts, msg[:cut])
+		msg = msg[cut:]
+	}
+	if len(msg) > 0 {
+		parts = append(parts, msg)
+	}
+	return parts
+}
+
+// TruncateMessage truncates msg to at most max bytes without splitting UTF-8 characters
+func TruncateMessage(msg string, max int) string {
+	return SplitMessage(msg, max)[0]
+}
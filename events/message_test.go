@@ -0,0 +1,24 @@
+package events_test
+
+import (
+	fevents "github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Message", func() {
+	It("sanitizes invalid UTF-8 and control characters", func() {
+		Expect(fevents.SanitizeMessage("a\xffb\x00c\x1b[0m\td\r\n")).To(Equal("a�bc[0m\td\r\n"))
+	})
+
+	It("splits messages on character boundaries", func() {
+		Expect(fevents.SplitMessage("abcdef", 4)).To(Equal([]string{"abcd", "ef"}))
+		Expect(fevents.SplitMessage("abc", 4)).To(Equal([]string{"abc"}))
+		Expect(fevents.SplitMessage("aéé", 4)).To(Equal([]string{"aé", "é"}))
+	})
+
+	It("truncates messages on character boundaries", func() {
+		Expect(fevents.TruncateMessage("aéé", 4)).To(Equal("aé"))
+		Expect(fevents.TruncateMessage("abc", 0)).To(Equal("abc"))
+	})
+})
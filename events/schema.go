@@ -0,0 +1,92 @@
+package events
+
+import (
+	"fmt"
+)
+
+// Output schemas. The default schema keeps the nozzle's own field names.
+const (
+	SchemaDefault = "default"
+	SchemaOTel    = "otel"
+	SchemaECS     = "ecs"
+)
+
+// otelSchema follows the OpenTelemetry semantic conventions
+var otelSchema = Transforms{
+	{Action: TransformCopy, Field: "cf_app_name", To: "service.name"},
+	{Action: TransformRename, Field: "cf_app_id", To: "cloudfoundry.app.id"},
+	{Action: TransformRename, Field: "cf_app_name", To: "cloudfoundry.app.name"},
+	{Action: TransformRename, Field: "cf_space_id", To: "cloudfoundry.space.id"},
+	{Action: TransformRename, Field: "cf_space_name", To: "cloudfoundry.space.name"},
+	{Action: TransformRename, Field: "cf_org_id", To: "cloudfoundry.org.id"},
+	{Action: TransformRename, Field: "cf_org_name", To: "cloudfoundry.org.name"},
+	{Action: TransformRename, Field: "cf_service_instances", To: "cloudfoundry.app.service_instances"},
+	{Action: TransformRename, Field: "source_instance", To: "cloudfoundry.app.instance.id", EventTypes: []string{"LogMessage"}},
+	{Action: TransformRename, Field: "instance_index", To: "cloudfoundry.app.instance.id"},
+	{Action: TransformRename, Field: "source_type", To: "cloudfoundry.process.type"},
+	{Action: TransformRename, Field: "origin", To: "cloudfoundry.system.id"},
+	{Action: TransformRename, Field: "job_index", To: "cloudfoundry.system.instance.id"},
+	{Action: TransformRename, Field: "deployment", To: "cloudfoundry.deployment"},
+	{Action: TransformRename, Field: "job", To: "cloudfoundry.job"},
+	{Action: TransformRename, Field: "ip", To: "host.ip"},
+	{Action: TransformRename, Field: "event_type", To: "cloudfoundry.event.type"},
+	{Action: TransformRename, Field: "msg", To: "body"},
+	{Action: TransformRename, Field: "method", To: "http.request.method"},
+	{Action: TransformRename, Field: "status_code", To: "http.response.status_code"},
+	{Action: TransformRename, Field: "uri", To: "url.full"},
+	{Action: TransformRename, Field: "remote_addr", To: "client.address"},
+	{Action: TransformRename, Field: "user_agent", To: "user_agent.original"},
+	{Action: TransformRename, Field: "content_length", To: "http.response.body.size"},
+	{Action: TransformRename, Field: "request_id", To: "http.request.id"},
+	{Action: TransformRename, Field: "name", To: "metric.name", EventTypes: []string{"ValueMetric", "CounterEvent"}},
+	{Action: TransformRename, Field: "unit", To: "metric.unit", EventTypes: []string{"ValueMetric"}},
+	{Action: TransformRename, Field: "code", To: "error.code", EventTypes: []string{"Error"}},
+	{Action: TransformRename, Field: "source", To: "error.type", EventTypes: []string{"Error"}},
+}
+
+// ecsSchema follows the Elastic Common Schema and the field names of the
+// Elastic Cloud Foundry integration
+var ecsSchema = Transforms{
+	{Action: TransformCopy, Field: "cf_app_name", To: "service.name"},
+	{Action: TransformRename, Field: "cf_app_id", To: "cloudfoundry.app.id"},
+	{Action: TransformRename, Field: "cf_app_name", To: "cloudfoundry.app.name"},
+	{Action: TransformRename, Field: "cf_space_id", To: "cloudfoundry.space.id"},
+	{Action: TransformRename, Field: "cf_space_name", To: "cloudfoundry.space.name"},
+	{Action: TransformRename, Field: "cf_org_id", To: "cloudfoundry.org.id"},
+	{Action: TransformRename, Field: "cf_org_name", To: "cloudfoundry.org.name"},
+	{Action: TransformRename, Field: "cf_service_instances", To: "cloudfoundry.app.service_instances"},
+	{Action: TransformRename, Field: "source_instance", To: "cloudfoundry.log.source.instance", EventTypes: []string{"LogMessage"}},
+	{Action: TransformRename, Field: "source_type", To: "cloudfoundry.log.source.type"},
+	{Action: TransformRename, Field: "instance_index", To: "cloudfoundry.app.instance_index"},
+	{Action: TransformRename, Field: "origin", To: "cloudfoundry.envelope.origin"},
+	{Action: TransformRename, Field: "deployment", To: "cloudfoundry.envelope.deployment"},
+	{Action: TransformRename, Field: "job", To: "cloudfoundry.envelope.job"},
+	{Action: TransformRename, Field: "job_index", To: "cloudfoundry.envelope.index"},
+	{Action: TransformRename, Field: "ip", To: "host.ip"},
+	{Action: TransformRename, Field: "event_type", To: "cloudfoundry.type"},
+	{Action: TransformRename, Field: "msg", To: "message"},
+	{Action: TransformRename, Field: "method", To: "http.request.method"},
+	{Action: TransformRename, Field: "status_code", To: "http.response.status_code"},
+	{Action: TransformRename, Field: "uri", To: "url.original"},
+	{Action: TransformRename, Field: "remote_addr", To: "source.address"},
+	{Action: TransformRename, Field: "user_agent", To: "user_agent.original"},
+	{Action: TransformRename, Field: "content_length", To: "http.response.body.bytes"},
+	{Action: TransformRename, Field: "request_id", To: "http.request.id"},
+	{Action: TransformRename, Field: "code", To: "error.code", EventTypes: []string{"Error"}},
+	{Action: TransformRename, Field: "source", To: "error.type", EventTypes: []string{"Error"}},
+}
+
+// SchemaTransforms returns the transforms which rename the nozzle's fields
+// to the given output schema
+func SchemaTransforms(schema string) (Transforms, error) {
+	switch schema {
+	case "", SchemaDefault:
+		return nil, nil
+	case SchemaOTel:
+		return otelSchema, nil
+	case SchemaECS:
+		return ecsSchema, nil
+	default:
+		return nil, fmt.Errorf("invalid output schema [%s] - valid schemas: %s, %s, %s", schema, SchemaDefault, SchemaOTel, SchemaECS)
+	}
+}
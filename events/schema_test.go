@@ -0,0 +1,60 @@
+package events_test
+
+import (
+	fevents "github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Schema", func() {
+	var fields map[string]interface{}
+
+	BeforeEach(func() {
+		fields = map[string]interface{}{
+			"cf_app_id":   "f47ac10b-58cc-4372-a567-0e02b2c3d479",
+			"cf_app_name": "MyApp",
+			"ip":          "10.0.0.1",
+			"status_code": 200,
+			"uri":         "http://myapp/",
+			"job_index":   "85c9ff80",
+		}
+	})
+
+	It("keeps field names with the default schema", func() {
+		transforms, err := fevents.SchemaTransforms(fevents.SchemaDefault)
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(transforms).To(BeEmpty())
+	})
+
+	It("rejects unknown schemas", func() {
+		_, err := fevents.SchemaTransforms("bogus")
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("renames fields to OpenTelemetry semantic conventions", func() {
+		transforms, err := fevents.SchemaTransforms(fevents.SchemaOTel)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		transforms.Apply("HttpStartStop", fields)
+		Expect(fields).To(Equal(map[string]interface{}{
+			"service.name":                    "MyApp",
+			"cloudfoundry.app.name":           "MyApp",
+			"cloudfoundry.app.id":             "f47ac10b-58cc-4372-a567-0e02b2c3d479",
+			"host.ip":                         "10.0.0.1",
+			"http.response.status_code":       200,
+			"url.full":                        "http://myapp/",
+			"cloudfoundry.system.instance.id": "85c9ff80",
+		}))
+	})
+
+	It("renames fields to Elastic Common Schema", func() {
+		transforms, err := fevents.SchemaTransforms(fevents.SchemaECS)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		transforms.Apply("HttpStartStop", fields)
+		Expect(fields["service.name"]).To(Equal("MyApp"))
+		Expect(fields["url.original"]).To(Equal("http://myapp/"))
+		Expect(fields["cloudfoundry.envelope.index"]).To(Equal("85c9ff80"))
+		Expect(fields).NotTo(HaveKey("uri"))
+	})
+})
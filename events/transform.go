@@ -0,0 +1,152 @@
+package events
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+)
+
+const (
+	TransformRename    = "rename"
+	TransformDrop      = "drop"
+	TransformCopy      = "copy"
+	TransformSet       = "set"
+	TransformLowercase = "lowercase"
+	TransformNest      = "nest"
+)
+
+// TransformRule reshapes the fields of parsed events. Field may end with a
+// '*' to match all fields with the given prefix for drop, lowercase and nest.
+type TransformRule struct {
+	EventTypes []string    `json:"event_types"` // empty applies to all event types
+	Action     string      `json:"action"`
+	Field      string      `json:"field"`
+	To         string      `json:"to"`    // target field for rename and copy, target object for nest
+	Value      interface{} `json:"value"` // constant for set
+}
+
+type Transforms []TransformRule
+
+// ParseTransforms parses transform rules from a JSON list or a file containing the list
+func ParseTransforms(transformsString string) (Transforms, error) {
+	if strings.TrimSpace(transformsString) == "" {
+		return nil, nil
+	}
+
+	var transforms Transforms
+	if err := utils.UnmarshalJSONOrFile(transformsString, &transforms); err != nil {
+		return nil, fmt.Errorf("failed to parse transforms: %s", err)
+	}
+
+	for _, rule := range transforms {
+		if rule.Field == "" {
+			return nil, fmt.Errorf("transform %+v must have a field", rule)
+		}
+		for _, eventType := range rule.EventTypes {
+			if !IsAuthorizedEvent(eventType) {
+				return nil, fmt.Errorf("rejected event name [%s] in transform - valid events: %s", eventType, AuthorizedEvents())
+			}
+		}
+
+		wildcard := strings.HasSuffix(rule.Field, "*")
+		switch rule.Action {
+		case TransformRename, TransformCopy, TransformNest:
+			if rule.To == "" {
+				return nil, fmt.Errorf("%s transform %+v must have a target", rule.Action, rule)
+			}
+			if wildcard && rule.Action != TransformNest {
+				return nil, fmt.Errorf("%s transform %+v doesn't support wildcards", rule.Action, rule)
+			}
+		case TransformSet:
+			if wildcard {
+				return nil, fmt.Errorf("%s transform %+v doesn't support wildcards", rule.Action, rule)
+			}
+		case TransformDrop, TransformLowercase:
+		default:
+			return nil, fmt.Errorf("invalid transform action [%s] - valid actions: %s", rule.Action,
+				strings.Join([]string{TransformRename, TransformDrop, TransformCopy, TransformSet, TransformLowercase, TransformNest}, ", "))
+		}
+	}
+	return transforms, nil
+}
+
+// Apply applies the rules matching the event type to fields in order
+func (t Transforms) Apply(eventType string, fields map[string]interface{}) {
+	for _, rule := range t {
+		if len(rule.EventTypes) > 0 && !contains(rule.EventTypes, eventType) {
+			continue
+		}
+
+		switch rule.Action {
+		case TransformRename:
+			if v, ok := fields[rule.Field]; ok {
+				delete(fields, rule.Field)
+				fields[rule.To] = v
+			}
+		case TransformCopy:
+			if v, ok := fields[rule.Field]; ok {
+				fields[rule.To] = v
+			}
+		case TransformSet:
+			fields[rule.Field] = rule.Value
+		case TransformDrop:
+			for _, k := range matchingFields(fields, rule.Field) {
+				delete(fields, k)
+			}
+		case TransformLowercase:
+			for _, k := range matchingFields(fields, rule.Field) {
+				if v, ok := fields[k].(string); ok {
+					fields[k] = strings.ToLower(v)
+				}
+			}
+		case TransformNest:
+			// The existing object may be shared, e.g. with a lookup row
+			nested := make(map[string]interface{})
+			if existing, ok := fields[rule.To].(map[string]interface{}); ok {
+				for k, v := range existing {
+					nested[k] = v
+				}
+			}
+			prefix := strings.TrimSuffix(rule.Field, "*")
+			for _, k := range matchingFields(fields, rule.Field) {
+				name := strings.TrimPrefix(k, prefix)
+				if name == "" {
+					name = k
+				}
+				nested[name] = fields[k]
+				delete(fields, k)
+			}
+			if len(nested) > 0 {
+				fields[rule.To] = nested
+			}
+		}
+	}
+}
+
+func matchingFields(fields map[string]interface{}, pattern string) []string {
+	if !strings.HasSuffix(pattern, "*") {
+		if _, ok := fields[pattern]; ok {
+			return []string{pattern}
+		}
+		return nil
+	}
+
+	prefix := strings.TrimSuffix(pattern, "*")
+	var matches []string
+	for k := range fields {
+		if strings.HasPrefix(k, prefix) {
+			matches = append(matches, k)
+		}
+	}
+	return matches
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package events_test
+
+import (
+	fevents "github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Transforms", func() {
+	var fields map[string]interface{}
+
+	BeforeEach(func() {
+		fields = map[string]interface{}{
+			"cf_app_id":     "f47ac10b-58cc-4372-a567-0e02b2c3d479",
+			"cf_app_name":   "MyApp",
+			"cf_org_name":   "MyOrg",
+			"job_index":     "85c9ff80",
+			"message_type":  "OUT",
+			"origin":        "rep",
+			"source_type":   "APP/PROC/WEB",
+			"event_type":    "LogMessage",
+			"instance_name": "web",
+		}
+	})
+
+	Context("ParseTransforms", func() {
+		It("returns no rules for an empty string", func() {
+			transforms, err := fevents.ParseTransforms("")
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(transforms).To(BeEmpty())
+		})
+
+		It("parses a JSON list", func() {
+			transforms, err := fevents.ParseTransforms(`[{"action":"rename","field":"cf_app_id","to":"app.guid","event_types":["LogMessage"]}]`)
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(transforms).To(Equal(fevents.Transforms{
+				{Action: "rename", Field: "cf_app_id", To: "app.guid", EventTypes: []string{"LogMessage"}},
+			}))
+		})
+
+		It("rejects invalid actions", func() {
+			_, err := fevents.ParseTransforms(`[{"action":"explode","field":"cf_app_id"}]`)
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("rejects invalid event types", func() {
+			_, err := fevents.ParseTransforms(`[{"action":"drop","field":"job_index","event_types":["bogus"]}]`)
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("rejects renames without target", func() {
+			_, err := fevents.ParseTransforms(`[{"action":"rename","field":"cf_app_id"}]`)
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("rejects wildcard renames", func() {
+			_, err := fevents.ParseTransforms(`[{"action":"rename","field":"cf_*","to":"cf"}]`)
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("Apply", func() {
+		It("renames, drops, copies, sets and lowercases fields", func() {
+			transforms, err := fevents.ParseTransforms(`[
+				{"action":"rename","field":"cf_app_id","to":"app.guid"},
+				{"action":"drop","field":"job_index"},
+				{"action":"copy","field":"origin","to":"component"},
+				{"action":"set","field":"platform","value":"cf"},
+				{"action":"lowercase","field":"cf_*"}
+			]`)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			transforms.Apply("LogMessage", fields)
+			Expect(fields).NotTo(HaveKey("cf_app_id"))
+			Expect(fields["app.guid"]).To(Equal("f47ac10b-58cc-4372-a567-0e02b2c3d479"))
+			Expect(fields).NotTo(HaveKey("job_index"))
+			Expect(fields["origin"]).To(Equal("rep"))
+			Expect(fields["component"]).To(Equal("rep"))
+			Expect(fields["platform"]).To(Equal("cf"))
+			Expect(fields["cf_app_name"]).To(Equal("myapp"))
+			Expect(fields["cf_org_name"]).To(Equal("myorg"))
+		})
+
+		It("nests fields under an object", func() {
+			transforms, err := fevents.ParseTransforms(`[{"action":"nest","field":"cf_*","to":"cf"}]`)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			transforms.Apply("LogMessage", fields)
+			Expect(fields["cf"]).To(Equal(map[string]interface{}{
+				"app_id":   "f47ac10b-58cc-4372-a567-0e02b2c3d479",
+				"app_name": "MyApp",
+				"org_name": "MyOrg",
+			}))
+			Expect(fields).NotTo(HaveKey("cf_app_id"))
+		})
+
+		It("nests fields without modifying the existing object", func() {
+			transforms, err := fevents.ParseTransforms(`[{"action":"nest","field":"cf_app_*","to":"cf"}]`)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			shared := map[string]interface{}{"team": "diego"}
+			fields["cf"] = shared
+			transforms.Apply("LogMessage", fields)
+			Expect(fields["cf"]).To(Equal(map[string]interface{}{
+				"team": "diego",
+				"id":   "f47ac10b-58cc-4372-a567-0e02b2c3d479",
+				"name": "MyApp",
+			}))
+			Expect(shared).To(Equal(map[string]interface{}{"team": "diego"}))
+		})
+
+		It("only applies rules of the event type", func() {
+			transforms, err := fevents.ParseTransforms(`[{"action":"drop","field":"job_index","event_types":["ContainerMetric"]}]`)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			transforms.Apply("LogMessage", fields)
+			Expect(fields).To(HaveKey("job_index"))
+
+			transforms.Apply("ContainerMetric", fields)
+			Expect(fields).NotTo(HaveKey("job_index"))
+		})
+	})
+})
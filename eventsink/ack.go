@@ -0,0 +1,107 @@
+package eventsink
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/diskqueue"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// acker removes the records of the disk queue once their events have been
+// sent, dead-lettered or dropped, so that the events still in the pipeline
+// are read again after a crash. Records are numbered in the order they are
+// read and acknowledged in that order, a record being held by the batches of
+// its events. A nil acker does nothing, events then leave the pipeline
+// untracked.
+type acker struct {
+	queue  *diskqueue.Queue
+	logger lager.Logger
+
+	lock    sync.Mutex
+	next    uint64                      // sequence of the next record read, from 1 as 0 means untracked
+	acked   uint64                      // records acknowledged to the queue
+	refs    map[uint64]int              // holders of the records not acknowledged yet
+	records map[*events.Envelope]uint64 // records handed over to the lanes
+}
+
+func newAcker(queue *diskqueue.Queue, logger lager.Logger) *acker {
+	return &acker{
+		queue:   queue,
+		logger:  logger,
+		next:    1,
+		refs:    make(map[uint64]int),
+		records: make(map[*events.Envelope]uint64),
+	}
+}
+
+// read moves past the peeked record of the queue and registers the event
+// decoded from it, or nil when it is undecodable. It returns the sequence of
+// the record.
+func (a *acker) read(msg *events.Envelope) uint64 {
+	a.queue.Advance()
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	seq := a.next
+	a.next++
+	a.refs[seq] = 1
+	if msg != nil {
+		a.records[msg] = seq
+	}
+	return seq
+}
+
+// take returns the sequence of the record of the event, 0 when untracked
+func (a *acker) take(msg *events.Envelope) uint64 {
+	if a == nil {
+		return 0
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	seq := a.records[msg]
+	delete(a.records, msg)
+	return seq
+}
+
+// hold keeps the record until one more done
+func (a *acker) hold(seq uint64) {
+	if a == nil || seq == 0 {
+		return
+	}
+	a.lock.Lock()
+	a.refs[seq]++
+	a.lock.Unlock()
+}
+
+// done releases the records, acknowledging the oldest ones which are no
+// longer held
+func (a *acker) done(seqs ...uint64) {
+	if a == nil {
+		return
+	}
+	a.lock.Lock()
+	for _, seq := range seqs {
+		if seq == 0 {
+			continue
+		}
+		if a.refs[seq]--; a.refs[seq] == 0 {
+			delete(a.refs, seq)
+		}
+	}
+	n := 0
+	for a.acked+uint64(n)+1 < a.next {
+		if _, held := a.refs[a.acked+uint64(n)+1]; held {
+			break
+		}
+		n++
+	}
+	a.acked += uint64(n)
+	a.lock.Unlock()
+
+	if n > 0 {
+		if err := a.queue.Ack(n); err != nil {
+			a.logger.Error("Unable to commit disk queue", err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package eventsink
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+)
+
+type appInstanceKey struct {
+	appID    string
+	instance string
+}
+
+// appMetrics rolls ContainerMetric events up per app, across its instances.
+// Only the latest sample of each instance in the window is accounted.
+type appMetrics struct {
+	mutex   sync.Mutex
+	samples map[appInstanceKey]map[string]interface{}
+}
+
+// Usage fields summed across instances
+var appMetricsSums = []string{"cpu_percentage", "memory_bytes", "disk_bytes"}
+
+// Usage fields maxed across instances
+var appMetricsMaxes = []string{"cpu_percentage", "memory_bytes", "disk_bytes", "memory_percentage", "disk_percentage", "cpu_entitlement_percentage"}
+
+func newAppMetrics() *appMetrics {
+	return &appMetrics{
+		samples: make(map[appInstanceKey]map[string]interface{}),
+	}
+}
+
+// add records the sample of a parsed ContainerMetric event
+func (a *appMetrics) add(fields map[string]interface{}) {
+	key := appInstanceKey{appID: fmt.Sprint(fields["cf_app_id"]), instance: fmt.Sprint(fields["instance_index"])}
+	sample := dimensionsOf(fields, appDimensions)
+	for _, name := range appMetricsMaxes {
+		if v, ok := toFloat64(fields[name]); ok {
+			sample[name] = v
+		}
+	}
+
+	a.mutex.Lock()
+	a.samples[key] = sample
+	a.mutex.Unlock()
+}
+
+// flush returns one metric event per app of the window and starts a new window
+func (a *appMetrics) flush(now time.Time, host string) []map[string]interface{} {
+	a.mutex.Lock()
+	samples := a.samples
+	a.samples = make(map[appInstanceKey]map[string]interface{})
+	a.mutex.Unlock()
+
+	apps := make(map[string]map[string]interface{})
+	for key, sample := range samples {
+		fields, ok := apps[key.appID]
+		if !ok {
+			fields = dimensionsOf(sample, appDimensions)
+			fields[metricPrefix+"app.instances"] = 0
+			apps[key.appID] = fields
+		}
+		fields[metricPrefix+"app.instances"] = fields[metricPrefix+"app.instances"].(int) + 1
+
+		for _, name := range appMetricsSums {
+			if v, ok := sample[name].(float64); ok {
+				sum, _ := fields[metricPrefix+"app."+name+".sum"].(float64)
+				fields[metricPrefix+"app."+name+".sum"] = sum + v
+			}
+		}
+		for _, name := range appMetricsMaxes {
+			if v, ok := sample[name].(float64); ok {
+				max, ok := fields[metricPrefix+"app."+name+".max"].(float64)
+				if !ok {
+					max = math.Inf(-1)
+				}
+				fields[metricPrefix+"app."+name+".max"] = math.Max(max, v)
+			}
+		}
+	}
+
+	timestamp := utils.NanoSecondsToSeconds(now.UnixNano())
+	metrics := make([]map[string]interface{}, 0, len(apps))
+	for _, fields := range apps {
+		metrics = append(metrics, metricEvent(timestamp, host, "cf:appmetrics", fields))
+	}
+	return metrics
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch value := v.(type) {
+	case float64:
+		return value, true
+	case uint64:
+		return float64(value), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,65 @@
+package eventsink_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+var _ = Describe("App metrics", func() {
+	It("aggregates the latest ContainerMetric of each instance per app", func() {
+		router, memSink, rconfig := newRouter("ContainerMetric")
+		rconfig.AddAppName = true
+		rconfig.QuotaThreshold = 10
+
+		appId := "f964a41c-76ac-42c1-b2ba-663da3ec22d5"
+		route := func(index int32, cpu float64, memory uint64) {
+			eventType := events.Envelope_ContainerMetric
+			quota := uint64(1000)
+			router.Route(&events.Envelope{
+				EventType: &eventType,
+				ContainerMetric: &events.ContainerMetric{
+					ApplicationId:    &appId,
+					InstanceIndex:    &index,
+					CpuPercentage:    &cpu,
+					MemoryBytes:      &memory,
+					MemoryBytesQuota: &quota,
+				},
+			})
+		}
+		route(0, 50, 100)
+		route(0, 10, 200) // latest sample of instance 0
+		route(1, 30, 950)
+
+		eventWriter := &testing.EventWriterMock{}
+		metricWriter := &testing.EventWriterMock{}
+		config := newSinkConfig()
+		config.AppMetricsWindow = time.Hour
+		config.MetricWriter = metricWriter
+		sink := newTestSink(config, rconfig, eventWriter)
+		sink.Open()
+		writeEvents(sink, memSink)
+		sink.Close()
+
+		Expect(eventWriter.CapturedEvents()).To(HaveLen(3))
+		last := eventWriter.CapturedEvents()[2]["event"].(map[string]interface{})
+		Expect(last["memory_percentage"]).To(Equal(95.0))
+		Expect(last["memory_near_quota"]).To(BeTrue())
+
+		Expect(metricWriter.CapturedEvents()).To(HaveLen(1))
+		event := metricWriter.CapturedEvents()[0]
+		Expect(event["sourcetype"]).To(Equal("cf:appmetrics"))
+		fields := event["fields"].(map[string]interface{})
+		Expect(fields["cf_app_id"]).To(Equal(appId))
+		Expect(fields["cf_app_name"]).To(Equal("testing-app"))
+		Expect(fields["metric_name:app.instances"]).To(Equal(2))
+		Expect(fields["metric_name:app.cpu_percentage.sum"]).To(Equal(40.0))
+		Expect(fields["metric_name:app.cpu_percentage.max"]).To(Equal(30.0))
+		Expect(fields["metric_name:app.memory_bytes.sum"]).To(Equal(1150.0))
+		Expect(fields["metric_name:app.memory_percentage.max"]).To(Equal(95.0))
+	})
+})
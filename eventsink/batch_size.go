@@ -0,0 +1,202 @@
+package eventsink
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
+)
+
+// batchSizer adapts the batch size of a writer to the HEC latency and errors.
+// It halves the batch size on errors, shrinks it when HEC answers slower than
+// the latency target and grows it back up to the maximum otherwise.
+type batchSizer struct {
+	min    int
+	max    int
+	target time.Duration
+
+	lock sync.Mutex
+	size int
+}
+
+func newBatchSizer(config *SplunkConfig) *batchSizer {
+	min := config.MinBatchSize
+	if min <= 0 || min > config.BatchSize {
+		min = config.BatchSize
+	}
+	return &batchSizer{
+		min:    min,
+		max:    config.BatchSize,
+		target: config.BatchLatencyTarget,
+		size:   config.BatchSize,
+	}
+}
+
+// limit returns the current batch size
+func (b *batchSizer) limit() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.size
+}
+
+func (b *batchSizer) observe(latency time.Duration, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch {
+	case err != nil:
+		b.size /= 2
+	case b.target > 0 && latency > b.target:
+		b.size -= b.size/4 + 1
+	default:
+		b.size += b.size/10 + 1
+	}
+
+	if b.size < b.min {
+		b.size = b.min
+	}
+	if b.size > b.max {
+		b.size = b.max
+	}
+}
+
+// sizedWriter feeds the batch sizer with the latency and errors of the writer
+type sizedWriter struct {
+	eventwriter.Writer
+	sizer *batchSizer
+}
+
+func (w *sizedWriter) Write(events []map[string]interface{}) (error, uint64) {
+	start := time.Now()
+	err, sent := w.Writer.Write(events)
+	w.sizer.observe(time.Since(start), err)
+	return err, sent
+}
+
+// eventSize estimates the bytes of the event in a HEC payload of the writer,
+// including the fields the writer adds to the event, e.g. its index
+func eventSize(writer eventwriter.Writer, event map[string]interface{}) int {
+	// events are separated by 2 new lines
+	size := jsonSize(event) + 2
+
+	adder, ok := writer.(eventwriter.FieldsAdder)
+	if !ok {
+		return size
+	}
+	for key, v := range adder.AddedFields(event) {
+		if old, ok := event[key]; ok {
+			size += jsonSize(v) - jsonSize(old)
+		} else {
+			size += 1 + stringSize(key) + 1 + jsonSize(v)
+		}
+	}
+	return size
+}
+
+// payloadWriter returns a writer of the destination, to estimate the fields
+// it adds to events. Tenant writers only differ from the default writer by
+// their HEC host and token.
+func (s *Splunk) payloadWriter(dest destination) eventwriter.Writer {
+	if dest.tenant == metricsPartition {
+		return s.config.MetricWriter
+	}
+	if len(s.writers) > 1 {
+		return s.writers[0]
+	}
+	return nil
+}
+
+// jsonSize estimates the bytes of v encoded as JSON without encoding it.
+// Escaped characters count for their longest escape so that the estimate
+// doesn't fall short of the payload.
+func jsonSize(v interface{}) int {
+	switch v := v.(type) {
+	case nil:
+		return len("null")
+	case string:
+		return stringSize(v)
+	case bool:
+		if v {
+			return len("true")
+		}
+		return len("false")
+	case int:
+		return len(strconv.FormatInt(int64(v), 10))
+	case int32:
+		return len(strconv.FormatInt(int64(v), 10))
+	case int64:
+		return len(strconv.FormatInt(v, 10))
+	case uint32:
+		return len(strconv.FormatUint(uint64(v), 10))
+	case uint64:
+		return len(strconv.FormatUint(v, 10))
+	case float64:
+		// Same format as encoding/json
+		format := byte('f')
+		if abs := math.Abs(v); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+			format = 'e'
+		}
+		return len(strconv.FormatFloat(v, format, -1, 64))
+	case map[string]interface{}:
+		size := 2 + commas(len(v))
+		for k, value := range v {
+			size += stringSize(k) + 1 + jsonSize(value)
+		}
+		return size
+	case map[string]string:
+		size := 2 + commas(len(v))
+		for k, value := range v {
+			size += stringSize(k) + 1 + stringSize(value)
+		}
+		return size
+	case []interface{}:
+		size := 2 + commas(len(v))
+		for _, value := range v {
+			size += jsonSize(value)
+		}
+		return size
+	case []map[string]string:
+		size := 2 + commas(len(v))
+		for _, value := range v {
+			size += jsonSize(value)
+		}
+		return size
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return 0
+		}
+		return len(data)
+	}
+}
+
+// stringSize estimates the bytes of s as a JSON string
+func stringSize(s string) int {
+	size := 2
+	for i, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			size += 2
+		case r < 0x20 || r == '<' || r == '>' || r == '&' || r == '\u2028' || r == '\u2029':
+			size += 6
+		case r == utf8.RuneError && !strings.HasPrefix(s[i:], "\ufffd"):
+			// invalid UTF-8 is replaced by \ufffd
+			size += 6
+		default:
+			size += utf8.RuneLen(r)
+		}
+	}
+	return size
+}
+
+func commas(n int) int {
+	if n == 0 {
+		return 0
+	}
+	return n - 1
+}
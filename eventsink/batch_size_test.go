@@ -0,0 +1,154 @@
+package eventsink_test
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+)
+
+var _ = Describe("Batch size", func() {
+	var (
+		memSink *testing.MemorySinkMock
+		lock    sync.Mutex
+		batches [][]map[string]interface{}
+		latency time.Duration
+		writer  *testing.EventWriterMock
+		config  *eventsink.SplunkConfig
+		rconfig *eventrouter.Config
+	)
+
+	BeforeEach(func() {
+		msgs := make([]string, 30)
+		for i := range msgs {
+			msgs[i] = strings.Repeat("x", 200)
+		}
+		memSink, rconfig = routeLogMessages(msgs...)
+
+		batches = nil
+		latency = 0
+		writer = &testing.EventWriterMock{
+			PostBatchFn: func(events []map[string]interface{}) error {
+				time.Sleep(latency)
+				lock.Lock()
+				batches = append(batches, events)
+				lock.Unlock()
+				return nil
+			},
+		}
+		config = newSinkConfig()
+		config.FlushInterval = 10 * time.Second
+		config.BatchSize = 8
+	})
+
+	// send queues all events before the consumer starts so that batches only
+	// depend on the batch limits
+	send := func() []int {
+		sink := newTestSink(config, rconfig, writer)
+		writeEvents(sink, memSink)
+		sink.Open()
+		sink.Close()
+
+		var sizes []int
+		for _, batch := range batches {
+			sizes = append(sizes, len(batch))
+		}
+		return sizes
+	}
+
+	It("limits batches by number of events", func() {
+		Expect(send()).To(Equal([]int{8, 8, 8, 6}))
+	})
+
+	It("limits batches by payload bytes", func() {
+		config.MaxBatchBytes = 2000
+		sizes := send()
+		Expect(len(sizes)).To(BeNumerically(">", 4))
+
+		total := 0
+		for i, batch := range batches {
+			payload := 0
+			for _, event := range batch {
+				data, _ := json.Marshal(event)
+				payload += len(data) + 2
+			}
+			Expect(payload).To(BeNumerically("<=", config.MaxBatchBytes))
+			Expect(sizes[i]).To(BeNumerically("<=", config.BatchSize))
+			total += sizes[i]
+		}
+		Expect(total).To(Equal(30))
+	})
+
+	It("doesn't underestimate the payload of escaped messages", func() {
+		for _, event := range memSink.Events {
+			event.LogMessage.Message = []byte(strings.Repeat("<\"\\\x01\xffé>", 20))
+		}
+		config.MaxBatchBytes = 2000
+		send()
+
+		Expect(len(batches)).To(BeNumerically(">", 4))
+		for _, batch := range batches {
+			payload := 0
+			for _, event := range batch {
+				data, _ := json.Marshal(event)
+				payload += len(data) + 2
+			}
+			Expect(payload).To(BeNumerically("<=", config.MaxBatchBytes))
+		}
+	})
+
+	It("accounts for the fields the writer adds to events", func() {
+		index := strings.Repeat("i", 100)
+		config.MaxBatchBytes = 2000
+		sink := newTestSink(config, rconfig, &indexWriter{EventWriterMock: writer, index: index})
+		writeEvents(sink, memSink)
+		sink.Open()
+		sink.Close()
+
+		Expect(len(batches)).To(BeNumerically(">", 4))
+		for _, batch := range batches {
+			payload := 0
+			for _, event := range batch {
+				event["index"] = index
+				data, _ := json.Marshal(event)
+				payload += len(data) + 2
+			}
+			Expect(payload).To(BeNumerically("<=", config.MaxBatchBytes))
+		}
+	})
+
+	It("shrinks batches when HEC is slower than the latency target", func() {
+		config.AdaptiveBatching = true
+		config.MinBatchSize = 2
+		config.BatchLatencyTarget = time.Millisecond
+		latency = 5 * time.Millisecond
+
+		sizes := send()
+		Expect(sizes[:5]).To(Equal([]int{8, 5, 3, 2, 2}))
+	})
+
+	It("keeps the maximum batch size when HEC is fast", func() {
+		config.AdaptiveBatching = true
+		config.MinBatchSize = 2
+		config.BatchLatencyTarget = time.Second
+
+		Expect(send()).To(Equal([]int{8, 8, 8, 6}))
+	})
+})
+
+// indexWriter is a writer setting its index on the events it sends
+type indexWriter struct {
+	*testing.EventWriterMock
+	index string
+}
+
+func (w *indexWriter) AddedFields(event map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"index": w.index}
+}
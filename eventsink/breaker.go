@@ -0,0 +1,254 @@
+package eventsink
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
+)
+
+// errBreakerOpen is the error of batches diverted while the circuit breaker is open
+var errBreakerOpen = errors.New("Splunk circuit breaker is open")
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// What workers do with their batches while the circuit breaker is open
+const (
+	BreakerActionPause      = "pause"
+	BreakerActionDeadLetter = "dead-letter"
+)
+
+// breakerStates are the values of the circuit breaker state metric
+var breakerStates = map[string]int{BreakerClosed: 0, BreakerOpen: 1, BreakerHalfOpen: 2}
+
+// circuitBreaker is shared by the writers of a HEC host and token, i.e. of a
+// tenant, the default destination or the metrics index. It opens after
+// consecutive outage failures so that workers stop hammering HEC with
+// retries, probes HEC health while open, then lets a single trial batch
+// through before closing.
+type circuitBreaker struct {
+	threshold     int
+	probeInterval time.Duration
+	healthCheck   func() error // nil lets the trial batch probe HEC
+	logger        lager.Logger
+	dest          destination // logged with the state changes
+
+	lock     sync.Mutex
+	state    string
+	failures int
+	trial    bool          // a trial batch is in flight while half-open
+	changed  chan struct{} // closed on state changes to wake up waiting workers
+	stopped  bool
+	stop     chan struct{}
+	probesWg sync.WaitGroup
+	logs     []lager.Data // state changes logged once the lock is released
+}
+
+func newCircuitBreaker(config *SplunkConfig, dest destination, tenant Tenant) *circuitBreaker {
+	var healthCheck func() error
+	if config.NewHealthCheck != nil {
+		healthCheck = config.NewHealthCheck(tenant)
+	}
+	return &circuitBreaker{
+		threshold:     config.BreakerThreshold,
+		probeInterval: config.BreakerProbeInterval,
+		healthCheck:   healthCheck,
+		logger:        config.Logger,
+		dest:          dest,
+		state:         BreakerClosed,
+		changed:       make(chan struct{}),
+		stop:          make(chan struct{}),
+	}
+}
+
+// isOutage tells whether the error means HEC is unavailable rather than the
+// batch being rejected, e.g. for an invalid index or token
+func isOutage(err error) bool {
+	var hecErr *eventwriter.HECError
+	if errors.As(err, &hecErr) {
+		return hecErr.StatusCode == 429 || hecErr.StatusCode >= 500
+	}
+	return true
+}
+
+// allow waits until the worker may send a batch. It returns false without
+// waiting when the breaker is open and the worker must not wait.
+func (b *circuitBreaker) allow(wait bool) bool {
+	for {
+		b.lock.Lock()
+		if b.stopped || b.state == BreakerClosed {
+			b.lock.Unlock()
+			return true
+		}
+		if b.state == BreakerHalfOpen && !b.trial {
+			b.trial = true
+			b.lock.Unlock()
+			return true
+		}
+		changed := b.changed
+		b.lock.Unlock()
+
+		if !wait {
+			return false
+		}
+		<-changed
+	}
+}
+
+// record accounts the result of a batch
+func (b *circuitBreaker) record(err error) {
+	b.lock.Lock()
+	defer b.unlock()
+
+	if err == nil {
+		b.failures = 0
+		if b.state != BreakerClosed {
+			b.setState(BreakerClosed)
+		}
+		return
+	}
+	if !isOutage(err) {
+		if b.state == BreakerHalfOpen {
+			// HEC answered, the trial batch was just rejected
+			b.setState(BreakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	switch {
+	case b.state == BreakerHalfOpen:
+		b.open()
+	case b.state == BreakerClosed && b.failures >= b.threshold:
+		b.open()
+	}
+}
+
+// open opens the breaker and starts probing HEC. It is called with the lock held.
+func (b *circuitBreaker) open() {
+	b.trial = false
+	b.setState(BreakerOpen)
+	if b.stopped {
+		return
+	}
+	b.probesWg.Add(1)
+	go b.probe(b.changed)
+}
+
+// probe half-opens the breaker once HEC is healthy
+func (b *circuitBreaker) probe(changed chan struct{}) {
+	defer b.probesWg.Done()
+
+	ticker := time.NewTicker(b.probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-changed:
+			return
+		case <-b.stop:
+			return
+		}
+
+		var err error
+		if b.healthCheck != nil {
+			err = b.healthCheck()
+		}
+		if err != nil {
+			data := b.dest.logData()
+			data["error"] = err.Error()
+			b.logger.Info("Splunk HEC is still unhealthy", data)
+			continue
+		}
+
+		b.lock.Lock()
+		if b.state == BreakerOpen {
+			b.setState(BreakerHalfOpen)
+		}
+		b.unlock()
+		return
+	}
+}
+
+// setState changes the state and wakes up the waiting workers. It is called
+// with the lock held.
+func (b *circuitBreaker) setState(state string) {
+	data := b.dest.logData()
+	data["from"] = b.state
+	data["state"] = state
+	data["consecutive_failures"] = b.failures
+	b.logs = append(b.logs, data)
+	if state == BreakerClosed {
+		b.failures = 0
+	}
+	b.state = state
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// unlock releases the lock and logs the state changes. Logs may be sent to
+// Splunk, so they are never written with the lock held.
+func (b *circuitBreaker) unlock() {
+	logs := b.logs
+	b.logs = nil
+	b.lock.Unlock()
+
+	for _, data := range logs {
+		b.logger.Info("Splunk circuit breaker state changed", data)
+	}
+}
+
+// current returns the current state
+func (b *circuitBreaker) current() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state
+}
+
+// shutdown stops probing and lets all the workers through, e.g. to drain the
+// queue on shutdown
+func (b *circuitBreaker) shutdown() {
+	b.lock.Lock()
+	if b.stopped {
+		b.lock.Unlock()
+		return
+	}
+	b.stopped = true
+	close(b.stop)
+	close(b.changed)
+	b.changed = make(chan struct{})
+	b.lock.Unlock()
+
+	b.probesWg.Wait()
+}
+
+// newBreakers returns the circuit breakers by tenant, "" being the default
+// HEC host and token and metricsPartition the metrics index
+func newBreakers(config *SplunkConfig) map[string]*circuitBreaker {
+	breakers := map[string]*circuitBreaker{"": newCircuitBreaker(config, destination{}, Tenant{})}
+	if config.MetricWriter != nil {
+		breakers[metricsPartition] = newCircuitBreaker(config, destination{tenant: metricsPartition}, Tenant{})
+	}
+	if config.NewTenantWriter != nil {
+		for _, t := range config.Tenants {
+			breakers[t.Name] = newCircuitBreaker(config, destination{tenant: t.Name}, t)
+		}
+	}
+	return breakers
+}
+
+// breakerOf returns the circuit breaker of the tenant, nil when the circuit
+// breakers are disabled. Tenants without their own writer share the default one.
+func (s *Splunk) breakerOf(tenant string) *circuitBreaker {
+	if b, ok := s.breakers[tenant]; ok {
+		return b
+	}
+	return s.breakers[""]
+}
@@ -0,0 +1,174 @@
+package eventsink_test
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/deadletter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+)
+
+var _ = Describe("Circuit breaker", func() {
+	var (
+		memSink *testing.MemorySinkMock
+		healthy int32
+		calls   int32
+		writer  *testing.EventWriterMock
+		logs    *logBuffer
+		probed  chan string // tenants of the health checks, "" for the default HEC host
+		config  *eventsink.SplunkConfig
+		rconfig *eventrouter.Config
+	)
+
+	BeforeEach(func() {
+		memSink, rconfig = routeLogMessages("first", "second")
+
+		healthy = 0
+		calls = 0
+		writer = &testing.EventWriterMock{
+			PostBatchFn: func(events []map[string]interface{}) error {
+				atomic.AddInt32(&calls, 1)
+				if atomic.LoadInt32(&healthy) == 0 {
+					return errors.New("connection refused")
+				}
+				return nil
+			},
+		}
+
+		logs = &logBuffer{}
+		config = newSinkConfig()
+		config.BatchSize = 1
+		config.Retries = 5
+		config.Logger = newBufferedLogger(logs)
+		config.BreakerThreshold = 1
+		config.BreakerProbeInterval = 50 * time.Millisecond
+		config.BreakerAction = eventsink.BreakerActionPause
+		probed = make(chan string, 100)
+		config.NewHealthCheck = func(tenant eventsink.Tenant) func() error {
+			return func() error {
+				select {
+				case probed <- tenant.Name:
+				default:
+				}
+				if atomic.LoadInt32(&healthy) == 0 {
+					return errors.New("HEC is unhealthy")
+				}
+				return nil
+			}
+		}
+	})
+
+	newSink := func() *eventsink.Splunk {
+		return newTestSink(config, rconfig, writer)
+	}
+
+	It("pauses the workers until HEC is healthy", func() {
+		sink := newSink()
+		sink.Open()
+		sink.Write(memSink.Events[0])
+
+		Eventually(func() int32 { return atomic.LoadInt32(&calls) }).Should(Equal(int32(1)))
+		Consistently(func() int32 { return atomic.LoadInt32(&calls) }, 300*time.Millisecond).Should(Equal(int32(1)))
+		Expect(logs.String()).To(ContainSubstring(`"state":"open"`))
+
+		atomic.StoreInt32(&healthy, 1)
+		Eventually(func() int32 { return atomic.LoadInt32(&calls) }).Should(Equal(int32(2)))
+		Eventually(logs.String).Should(ContainSubstring(`"state":"half-open"`))
+		Eventually(logs.String).Should(ContainSubstring(`"from":"half-open","state":"closed"`))
+
+		sink.Write(memSink.Events[1])
+		Eventually(func() int32 { return atomic.LoadInt32(&calls) }).Should(Equal(int32(3)))
+		sink.Close()
+		Expect(sink.SplunkDroppedEvents.Value()).To(BeZero())
+	})
+
+	It("doesn't open on rejected batches", func() {
+		writer.PostBatchFn = func(events []map[string]interface{}) error {
+			atomic.AddInt32(&calls, 1)
+			return &eventwriter.HECError{StatusCode: 400, Body: "Incorrect index"}
+		}
+		config.Retries = 1
+		sink := newSink()
+		sink.Open()
+		sink.Write(memSink.Events[0])
+		sink.Close()
+
+		Expect(sink.SplunkDroppedEvents.Value()).To(Equal(uint64(1)))
+		Expect(logs.String()).NotTo(ContainSubstring("circuit breaker state changed"))
+	})
+
+	It("keeps a circuit breaker per tenant", func() {
+		router, memSink, rconfig := newRouter("LogMessage")
+		rconfig.AddOrgName = true
+		appId := "f964a41c-76ac-42c1-b2ba-663da3ec22d5"
+		tenantEvent := logMessage("tenant")
+		tenantEvent.LogMessage.AppId = &appId
+		router.Route(tenantEvent)
+		router.Route(logMessage("default"))
+
+		config.Tenants = []eventsink.Tenant{{Name: "finance", Token: "1", Org: "testing-org"}}
+		config.NewTenantWriter = func(t eventsink.Tenant) eventwriter.Writer {
+			return writer
+		}
+		// Two HEC workers, the first one pauses on the tenant batch
+		defaultWriter := &testing.EventWriterMock{}
+		sink := newTestSink(config, rconfig, defaultWriter, defaultWriter)
+		sink.Open()
+		sink.Write(memSink.Events[0])
+		Eventually(logs.String).Should(ContainSubstring(`"state":"open","tenant":"finance"`))
+
+		// The outage of the tenant HEC host doesn't hold up the default one
+		sink.Write(memSink.Events[1])
+		Eventually(defaultWriter.CapturedEvents).Should(HaveLen(1))
+		Eventually(probed).Should(Receive(Equal("finance")))
+		Consistently(probed, 200*time.Millisecond).ShouldNot(Receive(Equal("")))
+
+		atomic.StoreInt32(&healthy, 1)
+		sink.Close()
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(2)))
+		Expect(sink.SplunkDroppedEvents.Value()).To(BeZero())
+	})
+
+	Context("with the dead-letter action", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = os.MkdirTemp("", "breaker")
+			Ω(err).ShouldNot(HaveOccurred())
+			config.DeadLetters, err = deadletter.NewStore(dir, 0)
+			Ω(err).ShouldNot(HaveOccurred())
+			config.BreakerAction = eventsink.BreakerActionDeadLetter
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("diverts batches to the dead-letter store while open", func() {
+			sink := newSink()
+			sink.Open()
+			writeEvents(sink, memSink)
+
+			Eventually(config.DeadLetters.List).Should(HaveLen(2))
+			sink.Close()
+			Expect(sink.DeadLetteredEvents.Value()).To(Equal(uint64(2)))
+			Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+			paths, _ := config.DeadLetters.List()
+			Expect(paths).To(HaveLen(2))
+			for _, path := range paths {
+				record, err := config.DeadLetters.Load(path)
+				Ω(err).ShouldNot(HaveOccurred())
+				Expect(record.ErrorType).To(Equal(deadletter.ErrorTypeBreakerOpen))
+			}
+		})
+	})
+})
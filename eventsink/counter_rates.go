@@ -0,0 +1,80 @@
+package eventsink
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+type counterSample struct {
+	total     uint64
+	timestamp int64 // envelope timestamp in nanoseconds
+	seen      time.Time
+}
+
+// counterRates keeps the last total of each CounterEvent series to compute
+// per-second rates. Series not seen for ttl are expired.
+type counterRates struct {
+	ttl       time.Duration
+	mutex     sync.Mutex
+	series    map[string]counterSample
+	lastSweep time.Time
+}
+
+func newCounterRates(ttl time.Duration) *counterRates {
+	return &counterRates{
+		ttl:       ttl,
+		series:    make(map[string]counterSample),
+		lastSweep: time.Now(),
+	}
+}
+
+// annotate adds the per-second rate of the CounterEvent to fields. It adds
+// nothing for the first sample of a series and for out of order samples.
+func (c *counterRates) annotate(msg *events.Envelope, fields map[string]interface{}) {
+	counter := msg.GetCounterEvent()
+	key := fmt.Sprintf("%s/%s/%s/%s/%s", msg.GetDeployment(), msg.GetOrigin(), msg.GetJob(), msg.GetIndex(), counter.GetName())
+	total := counter.GetTotal()
+	timestamp := msg.GetTimestamp()
+	now := time.Now()
+	if timestamp == 0 {
+		timestamp = now.UnixNano()
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.sweep(now)
+	last, ok := c.series[key]
+	if ok && timestamp <= last.timestamp {
+		// Out of order sample, keep the latest one
+		return
+	}
+	c.series[key] = counterSample{total: total, timestamp: timestamp, seen: now}
+	if !ok {
+		return
+	}
+
+	increase := total - last.total
+	if total < last.total {
+		// The counter was reset, e.g. by a restart of its emitter
+		increase = total
+		fields["counter_reset"] = true
+	}
+	fields["rate"] = float64(increase) / time.Duration(timestamp-last.timestamp).Seconds()
+}
+
+// sweep expires stale series, at most once per ttl
+func (c *counterRates) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	c.lastSweep = now
+	for key, sample := range c.series {
+		if now.Sub(sample.seen) >= c.ttl {
+			delete(c.series, key)
+		}
+	}
+}
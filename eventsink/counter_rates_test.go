@@ -0,0 +1,54 @@
+package eventsink_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+var _ = Describe("Counter rates", func() {
+	It("computes per-second rates of CounterEvent series", func() {
+		router, memSink, rconfig := newRouter("CounterEvent")
+
+		start := int64(1467040874046121775)
+		route := func(origin, name string, total uint64, offset time.Duration) {
+			eventType := events.Envelope_CounterEvent
+			timestamp := start + int64(offset)
+			router.Route(&events.Envelope{
+				EventType:    &eventType,
+				Origin:       &origin,
+				Timestamp:    &timestamp,
+				CounterEvent: &events.CounterEvent{Name: &name, Total: &total},
+			})
+		}
+		route("router", "requests", 100, 0)
+		route("router", "requests", 120, 2*time.Second)
+		route("router", "requests", 110, time.Second) // out of order
+		route("rep", "requests", 50, 2*time.Second)   // another series
+		route("router", "requests", 5, 3*time.Second) // reset
+
+		eventWriter := &testing.EventWriterMock{}
+		config := newSinkConfig()
+		config.CounterRateTTL = time.Minute
+		sink := newTestSink(config, rconfig, eventWriter)
+		sink.Open()
+		writeEvents(sink, memSink)
+		sink.Close()
+
+		var fields []map[string]interface{}
+		for _, event := range eventWriter.CapturedEvents() {
+			fields = append(fields, event["event"].(map[string]interface{}))
+		}
+		Expect(fields).To(HaveLen(5))
+		Expect(fields[0]).NotTo(HaveKey("rate"))
+		Expect(fields[1]["rate"]).To(Equal(10.0))
+		Expect(fields[2]).NotTo(HaveKey("rate"))
+		Expect(fields[3]).NotTo(HaveKey("rate"))
+		Expect(fields[4]["rate"]).To(Equal(5.0))
+		Expect(fields[4]["counter_reset"]).To(BeTrue())
+	})
+})
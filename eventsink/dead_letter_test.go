@@ -0,0 +1,89 @@
+package eventsink_test
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/deadletter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+)
+
+var _ = Describe("Dead letters", func() {
+	var (
+		dir         string
+		store       *deadletter.Store
+		memSink     *testing.MemorySinkMock
+		eventWriter *testing.EventWriterMock
+		config      *eventsink.SplunkConfig
+		rconfig     *eventrouter.Config
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "deadletter")
+		Ω(err).ShouldNot(HaveOccurred())
+		store, err = deadletter.NewStore(dir, 0)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		memSink, rconfig = routeLogMessages("lost")
+
+		eventWriter = &testing.EventWriterMock{
+			PostBatchFn: func(events []map[string]interface{}) error {
+				return &eventwriter.HECError{StatusCode: 503, Body: "Server is busy"}
+			},
+		}
+		config = newSinkConfig()
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("writes batches running out of retries to the dead-letter store", func() {
+		config.DeadLetters = store
+		sink := newTestSink(config, rconfig, eventWriter)
+		sink.Open()
+		sink.Write(memSink.Events[0])
+		sink.Close()
+
+		paths, _ := store.List()
+		record, err := store.Load(paths[0])
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(record.ErrorType).To(Equal("http_503"))
+		Expect(record.Response).To(Equal("Server is busy"))
+		Expect(record.Metrics).To(BeFalse())
+		Expect(record.Events).To(HaveLen(1))
+		Expect(sink.DeadLetteredEvents.Value()).To(Equal(uint64(1)))
+		Expect(sink.SplunkDroppedEvents.Value()).To(BeZero())
+	})
+
+	It("drops batches when the dead-letter store is full", func() {
+		var err error
+		config.DeadLetters, err = deadletter.NewStore(dir, 1)
+		Ω(err).ShouldNot(HaveOccurred())
+		sink := newTestSink(config, rconfig, eventWriter)
+		sink.Open()
+		sink.Write(memSink.Events[0])
+		sink.Close()
+
+		Expect(config.DeadLetters.List()).To(BeEmpty())
+		Expect(sink.DeadLetteredEvents.Value()).To(BeZero())
+		Expect(sink.DeadLetterFullEvents.Value()).To(Equal(uint64(1)))
+		Expect(sink.SplunkDroppedEvents.Value()).To(Equal(uint64(1)))
+	})
+
+	It("drops batches without a dead-letter store", func() {
+		sink := newTestSink(config, rconfig, eventWriter)
+		sink.Open()
+		sink.Write(memSink.Events[0])
+		sink.Close()
+
+		Expect(sink.SplunkDroppedEvents.Value()).To(Equal(uint64(1)))
+		Expect(sink.DeadLetteredEvents.Value()).To(BeZero())
+	})
+})
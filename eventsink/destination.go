@@ -0,0 +1,38 @@
+package eventsink
+
+import (
+	"code.cloudfoundry.org/lager"
+)
+
+// destination is where a batch is sent: the HEC host and token of a tenant
+// and an index. Batches never mix destinations, so a batch rejected by one
+// destination doesn't take down the events of the others.
+type destination struct {
+	tenant string // "" for the default HEC host and token, metricsPartition for the metrics index
+	index  string // "" for the default index of the writer
+}
+
+// destinationOf returns the destination of the event of the tenant
+func destinationOf(tenant string, fields map[string]interface{}) destination {
+	dest := destination{tenant: tenant}
+	if index, ok := fields["info_splunk_index"].(string); ok {
+		dest.index = index
+	}
+	return dest
+}
+
+// logData describes the destination in logs
+func (d destination) logData() lager.Data {
+	data := lager.Data{}
+	switch d.tenant {
+	case metricsPartition:
+		data["destination"] = "metrics"
+	case "":
+	default:
+		data["tenant"] = d.tenant
+	}
+	if d.index != "" {
+		data["index"] = d.index
+	}
+	return data
+}
@@ -0,0 +1,82 @@
+package eventsink_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+)
+
+var _ = Describe("Destination batching", func() {
+	var (
+		memSink  *testing.MemorySinkMock
+		appCache *testing.MemoryCacheMock
+		lock     sync.Mutex
+		batches  [][]string
+		config   *eventsink.SplunkConfig
+		rconfig  *eventrouter.Config
+		writer   *testing.EventWriterMock
+	)
+
+	BeforeEach(func() {
+		appCache = testing.NewMemoryCacheMock()
+		appCache.SetAppMetadata("app-a", map[string]string{cache.MetadataIndex: "forbidden"})
+		appCache.SetAppMetadata("app-b", map[string]string{cache.MetadataIndex: "main"})
+
+		var router eventrouter.Router
+		router, memSink, rconfig = newRouter("LogMessage")
+		for _, appId := range []string{"app-a", "app-b", "app-a", "app-b"} {
+			appId := appId
+			event := logMessage("hello")
+			event.LogMessage.AppId = &appId
+			router.Route(event)
+		}
+
+		batches = nil
+		writer = &testing.EventWriterMock{
+			PostBatchFn: func(events []map[string]interface{}) error {
+				var indexes []string
+				for _, event := range events {
+					indexes = append(indexes, event["event"].(map[string]interface{})["info_splunk_index"].(string))
+				}
+				lock.Lock()
+				batches = append(batches, indexes)
+				lock.Unlock()
+				if indexes[0] == "forbidden" {
+					return errors.New("incorrect index")
+				}
+				return nil
+			},
+		}
+		config = newSinkConfig()
+		config.FlushInterval = 100 * time.Millisecond
+		config.BatchSize = 10
+	})
+
+	sentBatches := func() [][]string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([][]string(nil), batches...)
+	}
+
+	It("partitions batches by index", func() {
+		sink := newCachedTestSink(config, rconfig, appCache, writer)
+		sink.Open()
+		writeEvents(sink, memSink)
+
+		// The worker sends the other batch once done retrying the rejected one
+		Eventually(sentBatches).Should(ConsistOf(
+			[]string{"forbidden", "forbidden"},
+			[]string{"main", "main"},
+		))
+		sink.Close()
+		Expect(sink.SplunkDroppedEvents.Value()).To(Equal(uint64(2)))
+	})
+})
@@ -0,0 +1,146 @@
+package eventsink_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/diskqueue"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+)
+
+var _ = Describe("Disk queue", func() {
+	var (
+		dir         string
+		queueConfig *diskqueue.Config
+		memSink     *testing.MemorySinkMock
+		eventWriter *testing.EventWriterMock
+		config      *eventsink.SplunkConfig
+		rconfig     *eventrouter.Config
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "eventsink")
+		Ω(err).ShouldNot(HaveOccurred())
+		queueConfig = &diskqueue.Config{
+			Dir:         dir,
+			SegmentSize: 1024,
+			SyncPolicy:  diskqueue.SyncNever,
+			Logger:      lager.NewLogger("test"),
+		}
+
+		memSink, rconfig = routeLogMessages("first", "second", "third")
+		eventWriter = &testing.EventWriterMock{}
+		config = newSinkConfig()
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	messages := func() []string {
+		var msgs []string
+		for _, event := range eventWriter.CapturedEvents() {
+			msgs = append(msgs, event["event"].(map[string]interface{})["msg"].(string))
+		}
+		return msgs
+	}
+
+	It("sends events through the disk queue", func() {
+		queue, err := diskqueue.Open(queueConfig)
+		Ω(err).ShouldNot(HaveOccurred())
+		config.Queue = queue
+
+		sink := newTestSink(config, rconfig, eventWriter)
+		sink.Open()
+		writeEvents(sink, memSink)
+		Eventually(messages).Should(Equal([]string{"first", "second", "third"}))
+		sink.Close()
+
+		Expect(queue.OldestAge()).To(Equal(time.Duration(0)))
+	})
+
+	It("sends the events left on disk by the previous run", func() {
+		queue, err := diskqueue.Open(queueConfig)
+		Ω(err).ShouldNot(HaveOccurred())
+		for _, event := range memSink.Events {
+			data, _ := event.Marshal()
+			queue.Push(data)
+		}
+		queue.Close()
+
+		queue, err = diskqueue.Open(queueConfig)
+		Ω(err).ShouldNot(HaveOccurred())
+		config.Queue = queue
+
+		sink := newTestSink(config, rconfig, eventWriter)
+		sink.Open()
+		Eventually(messages).Should(Equal([]string{"first", "second", "third"}))
+		sink.Close()
+	})
+
+	It("keeps the events on disk until their batch is sent", func() {
+		queueConfig.SyncPolicy = diskqueue.SyncAlways
+		queue, err := diskqueue.Open(queueConfig)
+		Ω(err).ShouldNot(HaveOccurred())
+		config.Queue = queue
+
+		sending := make(chan struct{})
+		release := make(chan struct{})
+		eventWriter.PostBatchFn = func(events []map[string]interface{}) error {
+			close(sending)
+			<-release
+			return nil
+		}
+		sink := newTestSink(config, rconfig, eventWriter)
+		sink.Open()
+		sink.Write(memSink.Events[0])
+		Eventually(sending).Should(BeClosed())
+
+		// Crash while the batch is in flight
+		crashDir, err := os.MkdirTemp("", "eventsink")
+		Ω(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(crashDir)
+		files, _ := os.ReadDir(dir)
+		for _, file := range files {
+			data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(os.WriteFile(filepath.Join(crashDir, file.Name()), data, 0600)).ShouldNot(HaveOccurred())
+		}
+		close(release)
+		sink.Close()
+
+		queueConfig.Dir = crashDir
+		crashQueue, err := diskqueue.Open(queueConfig)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer crashQueue.Close()
+		done := make(chan struct{})
+		close(done)
+		_, ok := crashQueue.Peek(done)
+		Expect(ok).To(BeTrue())
+
+		Expect(queue.OldestAge()).To(Equal(time.Duration(0)))
+	})
+
+	It("drops events when the disk queue is full", func() {
+		queueConfig.MaxSize = 1
+		queue, err := diskqueue.Open(queueConfig)
+		Ω(err).ShouldNot(HaveOccurred())
+		config.Queue = queue
+
+		sink := newTestSink(config, rconfig, eventWriter)
+		sink.Open()
+		sink.Write(memSink.Events[0])
+		sink.Close()
+
+		Expect(eventWriter.CapturedEvents()).To(BeEmpty())
+		Expect(sink.FirehoseDroppedEvents.Value()).To(Equal(uint64(1)))
+	})
+})
@@ -0,0 +1,133 @@
+package eventsink
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// drainSpillTimeout bounds the wait for the workers once the drain deadline
+// has passed, e.g. for HEC requests which never return
+const drainSpillTimeout = 2 * time.Second
+
+// errDrainDeadline is the error of batches spilled after the drain deadline
+var errDrainDeadline = errors.New("drain deadline exceeded")
+
+// ShutdownReport counts the events of the sink since it was created.
+// Firehose events are counted before parsing, HEC events after.
+type ShutdownReport struct {
+	Received     uint64 // firehose events written to the sink
+	QueueDropped uint64 // firehose events dropped on a full queue or left in the queue on shutdown
+	Sent         uint64 // HEC events accepted by Splunk
+	Dropped      uint64 // HEC events dropped after retries or abandoned on shutdown
+	DeadLettered uint64 // HEC events written to the dead-letter store after retries
+	Spilled      uint64 // HEC events written to the dead-letter store after the drain deadline
+}
+
+// Report returns the counts of the sink
+func (s *Splunk) Report() ShutdownReport {
+	return ShutdownReport{
+		Received:     atomic.LoadUint64(&s.report.Received),
+		QueueDropped: atomic.LoadUint64(&s.report.QueueDropped),
+		Sent:         atomic.LoadUint64(&s.report.Sent),
+		Dropped:      atomic.LoadUint64(&s.report.Dropped),
+		DeadLettered: atomic.LoadUint64(&s.report.DeadLettered),
+		Spilled:      atomic.LoadUint64(&s.report.Spilled),
+	}
+}
+
+func (s *Splunk) logReport() {
+	report := s.Report()
+	s.config.Logger.Info("Shutdown report", lager.Data{
+		"received":      report.Received,
+		"queue_dropped": report.QueueDropped,
+		"sent":          report.Sent,
+		"dropped":       report.Dropped,
+		"dead_lettered": report.DeadLettered,
+		"spilled":       report.Spilled,
+	})
+}
+
+// drainExpired tells whether the drain deadline has passed
+func (s *Splunk) drainExpired() bool {
+	select {
+	case <-s.drainDeadline:
+		return true
+	default:
+		return false
+	}
+}
+
+// drain waits until the workers have sent the queued events. Once the drain
+// deadline has passed, workers spill their batches instead of sending them,
+// and the events of the workers still busy after drainSpillTimeout are
+// counted as dropped.
+func (s *Splunk) drain(drained chan struct{}) {
+	if s.config.DrainTimeout <= 0 {
+		<-drained
+		return
+	}
+
+	// The deadline started with Stop
+	timer := time.NewTimer(s.config.DrainTimeout - time.Since(s.stopped))
+	defer timer.Stop()
+	select {
+	case <-drained:
+		return
+	case <-timer.C:
+	}
+
+	s.config.Logger.Info("Drain deadline exceeded, spilling remaining events", lager.Data{"drain_timeout": s.config.DrainTimeout.String()})
+	close(s.drainDeadline)
+	select {
+	case <-drained:
+		return
+	case <-time.After(drainSpillTimeout):
+	}
+
+	// Workers are stuck, most likely on HEC requests
+	abandoned := atomic.LoadUint64(&s.inFlight)
+	queued := 0
+	for _, l := range s.lanes {
+	BATCHES:
+		for {
+			select {
+			case batch, ok := <-l.batches:
+				if !ok {
+					break BATCHES
+				}
+				abandoned += uint64(len(batch.events))
+			default:
+				break BATCHES
+			}
+		}
+		queued += discard(l.events)
+		for _, queue := range l.workerQueues {
+			queued += discard(queue)
+		}
+	}
+	s.SplunkDroppedEvents.Add(abandoned)
+	atomic.AddUint64(&s.report.Dropped, abandoned)
+	s.FirehoseDroppedEvents.Add(queued)
+	atomic.AddUint64(&s.report.QueueDropped, uint64(queued))
+	s.config.Logger.Error("Giving up on HEC workers after the drain deadline", nil, lager.Data{"abandoned_events": abandoned, "queued_events": queued})
+}
+
+// discard empties the queue without waiting and returns the number of events
+func discard(queue chan *events.Envelope) int {
+	discarded := 0
+	for {
+		select {
+		case _, ok := <-queue:
+			if !ok {
+				return discarded
+			}
+			discarded++
+		default:
+			return discarded
+		}
+	}
+}
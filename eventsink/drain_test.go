@@ -0,0 +1,109 @@
+package eventsink_test
+
+import (
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/deadletter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+)
+
+var _ = Describe("Drain", func() {
+	var (
+		memSink *testing.MemorySinkMock
+		writer  *testing.EventWriterMock
+		logs    *logBuffer
+		config  *eventsink.SplunkConfig
+		rconfig *eventrouter.Config
+	)
+
+	BeforeEach(func() {
+		memSink, rconfig = routeLogMessages("first", "second", "third")
+
+		writer = &testing.EventWriterMock{}
+		logs = &logBuffer{}
+		config = newSinkConfig()
+		config.FlushInterval = 10 * time.Second
+		config.Retries = 3
+		// Back off for seconds between retries so that the drain deadline passes
+		config.RetryInterval = 0
+		config.Logger = newBufferedLogger(logs)
+	})
+
+	// shutdown writes the events and closes the sink, returning how long Close took
+	shutdown := func() (*eventsink.Splunk, time.Duration) {
+		sink := newTestSink(config, rconfig, writer)
+		Expect(sink.Open()).To(Succeed())
+		writeEvents(sink, memSink)
+		start := time.Now()
+		sink.Close()
+		return sink, time.Since(start)
+	}
+
+	It("sends the queued events and reports them", func() {
+		sink, _ := shutdown()
+
+		Expect(writer.CapturedEvents()).To(HaveLen(3))
+		Expect(sink.Report()).To(Equal(eventsink.ShutdownReport{Received: 3, Sent: 3}))
+		Expect(logs.String()).To(ContainSubstring(`"message":"test.Shutdown report"`))
+		Expect(logs.String()).To(ContainSubstring(`"received":3`))
+		Expect(logs.String()).To(ContainSubstring(`"sent":3`))
+	})
+
+	Context("with a drain deadline", func() {
+		BeforeEach(func() {
+			config.DrainTimeout = 100 * time.Millisecond
+			writer.PostBatchFn = func(events []map[string]interface{}) error {
+				return &eventwriter.HECError{StatusCode: 503, Body: "Server is busy"}
+			}
+		})
+
+		It("spills the remaining events to the dead-letter store", func() {
+			dir, err := os.MkdirTemp("", "drain")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			config.DeadLetters, err = deadletter.NewStore(dir, 0)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			sink, elapsed := shutdown()
+
+			// Without the deadline, retries would sleep 5s first
+			Expect(elapsed).To(BeNumerically("<", 2*time.Second))
+			Expect(sink.Report()).To(Equal(eventsink.ShutdownReport{Received: 3, Spilled: 3}))
+			paths, _ := config.DeadLetters.List()
+			Expect(paths).To(HaveLen(1))
+			record, err := config.DeadLetters.Load(paths[0])
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(record.Events).To(HaveLen(3))
+			Expect(logs.String()).To(ContainSubstring("Drain deadline exceeded"))
+		})
+
+		It("drops the remaining events without a dead-letter store", func() {
+			sink, elapsed := shutdown()
+
+			Expect(elapsed).To(BeNumerically("<", 2*time.Second))
+			Expect(sink.Report()).To(Equal(eventsink.ShutdownReport{Received: 3, Dropped: 3}))
+		})
+
+		It("gives up on HEC requests which never return", func() {
+			release := make(chan struct{})
+			defer close(release)
+			writer.PostBatchFn = func(events []map[string]interface{}) error {
+				<-release
+				return nil
+			}
+
+			sink, elapsed := shutdown()
+
+			Expect(elapsed).To(BeNumerically("<", 4*time.Second))
+			Expect(sink.Report()).To(Equal(eventsink.ShutdownReport{Received: 3, Dropped: 3}))
+			Expect(logs.String()).To(ContainSubstring("Giving up on HEC workers"))
+		})
+	})
+})
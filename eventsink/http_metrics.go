@@ -0,0 +1,189 @@
+package eventsink
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+)
+
+const metricPrefix = "metric_name:"
+
+// durationSamples bounds the request durations kept per aggregate and window.
+// Percentiles are exact up to that many requests and estimated from a
+// uniform sample of the durations beyond.
+const durationSamples = 1024
+
+// App dimensions copied from events onto their aggregates
+var appDimensions = []string{
+	"cf_app_id",
+	"cf_app_name",
+	"cf_space_id",
+	"cf_space_name",
+	"cf_org_id",
+	"cf_org_name",
+}
+
+// aggregator rolls events up into metrics sent every window
+type aggregator interface {
+	flush(now time.Time, host string) []map[string]interface{}
+}
+
+type httpMetricsKey struct {
+	appID    string
+	instance string
+	route    string
+	peerType string
+}
+
+type httpMetricsBucket struct {
+	dimensions map[string]interface{}
+	requests   uint64
+	classes    map[string]uint64 // response count by status class, e.g. "5xx"
+	durations  []float64         // reservoir sample of the request durations
+	timed      uint64            // requests with a duration
+}
+
+// addDuration keeps a uniform sample of the durations (reservoir sampling)
+func (b *httpMetricsBucket) addDuration(duration float64) {
+	b.timed++
+	if len(b.durations) < durationSamples {
+		b.durations = append(b.durations, duration)
+		return
+	}
+	if i := rand.Int63n(int64(b.timed)); i < durationSamples {
+		b.durations[i] = duration
+	}
+}
+
+// httpMetrics rolls HttpStartStop events up into request, error and
+// latency (RED) metrics per app, instance and route
+type httpMetrics struct {
+	mutex   sync.Mutex
+	buckets map[httpMetricsKey]*httpMetricsBucket
+}
+
+func newHttpMetrics() *httpMetrics {
+	return &httpMetrics{
+		buckets: make(map[httpMetricsKey]*httpMetricsBucket),
+	}
+}
+
+// add accounts a parsed HttpStartStop event into the current window
+func (h *httpMetrics) add(fields map[string]interface{}) {
+	route := routeOf(fields["uri"])
+	key := httpMetricsKey{
+		appID:    fmt.Sprint(fields["cf_app_id"]),
+		instance: fmt.Sprint(fields["instance_index"]),
+		route:    route,
+		peerType: fmt.Sprint(fields["peer_type"]),
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	bucket, ok := h.buckets[key]
+	if !ok {
+		dimensions := dimensionsOf(fields, appDimensions)
+		dimensions["route"] = route
+		dimensions["instance_index"] = fields["instance_index"]
+		dimensions["peer_type"] = fields["peer_type"]
+		bucket = &httpMetricsBucket{
+			dimensions: dimensions,
+			classes:    make(map[string]uint64),
+		}
+		h.buckets[key] = bucket
+	}
+
+	bucket.requests++
+	if status, ok := fields["status_code"].(int32); ok && status > 0 {
+		bucket.classes[fmt.Sprintf("%dxx", status/100)]++
+	}
+	if duration, ok := fields["duration_ms"].(int64); ok {
+		bucket.addDuration(float64(duration))
+	}
+}
+
+// flush returns one metric event per aggregate of the window and starts a new window
+func (h *httpMetrics) flush(now time.Time, host string) []map[string]interface{} {
+	h.mutex.Lock()
+	buckets := h.buckets
+	h.buckets = make(map[httpMetricsKey]*httpMetricsBucket)
+	h.mutex.Unlock()
+
+	timestamp := utils.NanoSecondsToSeconds(now.UnixNano())
+	metrics := make([]map[string]interface{}, 0, len(buckets))
+	for _, bucket := range buckets {
+		fields := bucket.dimensions
+		fields[metricPrefix+"http.requests.count"] = bucket.requests
+
+		var errors uint64
+		for class, count := range bucket.classes {
+			fields[metricPrefix+"http.responses."+class+".count"] = count
+			if class == "4xx" || class == "5xx" {
+				errors += count
+			}
+		}
+		fields[metricPrefix+"http.errors.count"] = errors
+
+		if len(bucket.durations) > 0 {
+			sort.Float64s(bucket.durations)
+			fields[metricPrefix+"http.duration_ms.p50"] = percentile(bucket.durations, 50)
+			fields[metricPrefix+"http.duration_ms.p90"] = percentile(bucket.durations, 90)
+			fields[metricPrefix+"http.duration_ms.p99"] = percentile(bucket.durations, 99)
+		}
+
+		metrics = append(metrics, metricEvent(timestamp, host, "cf:httpmetrics", fields))
+	}
+	return metrics
+}
+
+// metricEvent builds a HEC multiple-metric event
+func metricEvent(timestamp, host, sourcetype string, fields map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"time":       timestamp,
+		"host":       host,
+		"source":     "splunk_nozzle",
+		"sourcetype": sourcetype,
+		"event":      "metric",
+		"fields":     fields,
+	}
+}
+
+func dimensionsOf(fields map[string]interface{}, names []string) map[string]interface{} {
+	dimensions := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		if v, ok := fields[name]; ok {
+			dimensions[name] = v
+		}
+	}
+	return dimensions
+}
+
+// routeOf returns the host of the request URI, paths are left out to keep
+// the number of aggregates bounded
+func routeOf(uri interface{}) string {
+	s, _ := uri.(string)
+	if !strings.Contains(s, "://") {
+		s = "//" + s
+	}
+	if u, err := url.Parse(s); err == nil {
+		return u.Host
+	}
+	return ""
+}
+
+// percentile picks the nearest-rank percentile p of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
@@ -0,0 +1,132 @@
+package eventsink_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+var _ = Describe("HTTP metrics", func() {
+	var (
+		memSink      *testing.MemorySinkMock
+		router       eventrouter.Router
+		eventWriter  *testing.EventWriterMock
+		metricWriter *testing.EventWriterMock
+		config       *eventsink.SplunkConfig
+		rconfig      *eventrouter.Config
+	)
+
+	request := func(router eventrouter.Router, uri string, status int32, durationMs int64) {
+		eventType := events.Envelope_HttpStartStop
+		start := int64(1467040874046121775)
+		stop := start + durationMs*int64(time.Millisecond)
+		index := int32(0)
+		peerType := events.PeerType_Client
+		router.Route(&events.Envelope{
+			EventType: &eventType,
+			HttpStartStop: &events.HttpStartStop{
+				StartTimestamp: &start,
+				StopTimestamp:  &stop,
+				Uri:            &uri,
+				StatusCode:     &status,
+				InstanceIndex:  &index,
+				PeerType:       &peerType,
+			},
+		})
+	}
+
+	BeforeEach(func() {
+		router, memSink, rconfig = newRouter("HttpStartStop")
+
+		for i := int64(1); i <= 10; i++ {
+			request(router, "http://myapp.example.com/items/1", 200, i*10)
+		}
+		request(router, "http://myapp.example.com/items/2", 404, 5)
+		request(router, "http://myapp.example.com/items/3", 503, 1000)
+		request(router, "other.example.com/", 200, 1)
+
+		eventWriter = &testing.EventWriterMock{}
+		metricWriter = &testing.EventWriterMock{}
+		config = newSinkConfig()
+		config.HttpMetricsWindow = time.Hour
+		config.MetricWriter = metricWriter
+	})
+
+	run := func() {
+		sink := newTestSink(config, rconfig, eventWriter)
+		sink.Open()
+		writeEvents(sink, memSink)
+		sink.Close()
+	}
+
+	metricsOfRoute := func(route string) map[string]interface{} {
+		for _, event := range metricWriter.CapturedEvents() {
+			fields := event["fields"].(map[string]interface{})
+			if fields["route"] == route {
+				return fields
+			}
+		}
+		return nil
+	}
+
+	It("aggregates requests per route", func() {
+		run()
+
+		Expect(eventWriter.CapturedEvents()).To(BeEmpty())
+		Expect(metricWriter.CapturedEvents()).To(HaveLen(2))
+		Expect(metricWriter.CapturedEvents()[0]["sourcetype"]).To(Equal("cf:httpmetrics"))
+
+		fields := metricsOfRoute("myapp.example.com")
+		Expect(fields).NotTo(BeNil())
+		Expect(fields["peer_type"]).To(Equal("Client"))
+		Expect(fields["metric_name:http.requests.count"]).To(Equal(uint64(12)))
+		Expect(fields["metric_name:http.responses.2xx.count"]).To(Equal(uint64(10)))
+		Expect(fields["metric_name:http.responses.4xx.count"]).To(Equal(uint64(1)))
+		Expect(fields["metric_name:http.responses.5xx.count"]).To(Equal(uint64(1)))
+		Expect(fields["metric_name:http.errors.count"]).To(Equal(uint64(2)))
+		Expect(fields["metric_name:http.duration_ms.p50"]).To(Equal(float64(50)))
+		Expect(fields["metric_name:http.duration_ms.p90"]).To(Equal(float64(100)))
+		Expect(fields["metric_name:http.duration_ms.p99"]).To(Equal(float64(1000)))
+
+		fields = metricsOfRoute("other.example.com")
+		Expect(fields).NotTo(BeNil())
+		Expect(fields["metric_name:http.requests.count"]).To(Equal(uint64(1)))
+		Expect(fields["metric_name:http.errors.count"]).To(Equal(uint64(0)))
+	})
+
+	It("estimates the percentiles of busy routes from a bounded sample", func() {
+		for i := int64(1); i <= 5000; i++ {
+			request(router, "http://busy.example.com/", 200, i)
+		}
+		config.QueueSize = 10000
+		run()
+
+		fields := metricsOfRoute("busy.example.com")
+		Expect(fields).NotTo(BeNil())
+		Expect(fields["metric_name:http.requests.count"]).To(Equal(uint64(5000)))
+		Expect(fields["metric_name:http.duration_ms.p50"]).To(BeNumerically("~", 2500, 500))
+		Expect(fields["metric_name:http.duration_ms.p90"]).To(BeNumerically("~", 4500, 300))
+	})
+
+	It("keeps raw events when asked to", func() {
+		config.HttpMetricsKeepRaw = true
+		run()
+
+		Expect(eventWriter.CapturedEvents()).To(HaveLen(13))
+		Expect(metricWriter.CapturedEvents()).To(HaveLen(2))
+	})
+
+	It("sends raw events without a metric writer", func() {
+		config.MetricWriter = nil
+		run()
+
+		Expect(eventWriter.CapturedEvents()).To(HaveLen(13))
+		Expect(metricWriter.CapturedEvents()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,187 @@
+package eventsink
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// Lane is a queue of the events of some event types with its own share of the
+// HEC workers, so that floods of some event types don't starve the others
+type Lane struct {
+	Name           string   `json:"name"`
+	Events         []string `json:"events"`          // event types, the last lane also gets the event types of no lane
+	Weight         int      `json:"weight"`          // share of the HEC workers, defaults to 1
+	QueueSize      int      `json:"queue_size"`      // defaults to the consumer queue size
+	OverflowPolicy string   `json:"overflow_policy"` // defaults to the overflow policy of the nozzle
+}
+
+// ParseLanes parses priority lanes from a JSON list or a file containing the list
+func ParseLanes(lanesString string) ([]Lane, error) {
+	lanesString = strings.TrimSpace(lanesString)
+	if lanesString == "" {
+		return nil, nil
+	}
+
+	var lanes []Lane
+	if err := utils.UnmarshalJSONOrFile(lanesString, &lanes); err != nil {
+		return nil, fmt.Errorf("failed to parse priority lanes: %s", err)
+	}
+
+	names := make(map[string]bool, len(lanes))
+	eventTypes := make(map[string]string)
+	for i := range lanes {
+		l := &lanes[i]
+		if l.Name == "" {
+			return nil, fmt.Errorf("priority lane %+v must have a name", *l)
+		}
+		if names[l.Name] {
+			return nil, fmt.Errorf("duplicate priority lane name %s", l.Name)
+		}
+		names[l.Name] = true
+
+		for _, eventType := range l.Events {
+			if _, ok := events.Envelope_EventType_value[eventType]; !ok {
+				return nil, fmt.Errorf("unknown event type %s of priority lane %s", eventType, l.Name)
+			}
+			if other, ok := eventTypes[eventType]; ok {
+				return nil, fmt.Errorf("event type %s is in priority lanes %s and %s", eventType, other, l.Name)
+			}
+			eventTypes[eventType] = l.Name
+		}
+
+		if l.Weight < 0 || l.QueueSize < 0 {
+			return nil, fmt.Errorf("priority lane %s must have a positive weight and queue size", l.Name)
+		}
+		if l.Weight == 0 {
+			l.Weight = 1
+		}
+		switch l.OverflowPolicy {
+		case "", OverflowDropNewest, OverflowDropOldest, OverflowBlock:
+		default:
+			return nil, fmt.Errorf("unknown overflow policy %s of priority lane %s", l.OverflowPolicy, l.Name)
+		}
+	}
+	return lanes, nil
+}
+
+// lane is the queue of the events of a Lane
+type lane struct {
+	name           string
+	events         chan *events.Envelope
+	overflowPolicy string
+	weight         int
+
+	// With decoupled stages, batches wait in the send queue of the lane and
+	// the batch sizers are shared by the workers of the lane
+	batches chan pendingBatch
+	sizerOf func(tenant string) *batchSizer
+
+	// With ordered delivery, each worker of the lane has its own queue
+	workerQueues []chan *events.Envelope
+	dispatched   []uint64 // events dispatched to each worker since the imbalance was last read
+}
+
+// newLanes creates the queues of the configured lanes, or a single queue of
+// all events without lanes
+func newLanes(config *SplunkConfig) ([]*lane, map[events.Envelope_EventType]*lane) {
+	lanes := config.Lanes
+	if len(lanes) == 0 {
+		lanes = []Lane{{Name: "default", Weight: 1}}
+	}
+
+	queues := make([]*lane, 0, len(lanes))
+	byEventType := make(map[events.Envelope_EventType]*lane)
+	for _, l := range lanes {
+		queueSize := l.QueueSize
+		if queueSize == 0 {
+			queueSize = config.QueueSize
+		}
+		if config.Queue != nil && config.BatchSize < queueSize {
+			// Events are queued on disk, the channel is only a front buffer
+			queueSize = config.BatchSize
+		}
+		weight := l.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		overflowPolicy := l.OverflowPolicy
+		if overflowPolicy == "" {
+			overflowPolicy = config.OverflowPolicy
+		}
+
+		q := &lane{
+			name:           l.Name,
+			events:         make(chan *events.Envelope, queueSize),
+			overflowPolicy: overflowPolicy,
+			weight:         weight,
+			batches:        make(chan pendingBatch, config.SendQueueSize),
+		}
+		q.sizerOf = newSizers(config)
+		queues = append(queues, q)
+		for _, eventType := range l.Events {
+			byEventType[events.Envelope_EventType(events.Envelope_EventType_value[eventType])] = q
+		}
+	}
+	return queues, byEventType
+}
+
+// laneOf returns the lane of the event type, the last lane by default
+func (s *Splunk) laneOf(eventType events.Envelope_EventType) *lane {
+	if l, ok := s.lanesByEventType[eventType]; ok {
+		return l
+	}
+	return s.lanes[len(s.lanes)-1]
+}
+
+// queueUsage returns the number of queued events and the capacity of the lanes
+func (s *Splunk) queueUsage() (int, int) {
+	length, capacity := 0, 0
+	for _, l := range s.lanes {
+		length += len(l.events)
+		capacity += cap(l.events)
+		for _, queue := range l.workerQueues {
+			length += len(queue)
+			capacity += cap(queue)
+		}
+	}
+	return length, capacity
+}
+
+// laneWorkers splits the workers between the lanes by weight, every lane
+// getting at least one worker
+func laneWorkers(lanes []*lane, workers int) ([]int, error) {
+	if workers < len(lanes) {
+		return nil, fmt.Errorf("%d HEC workers can't serve %d priority lanes", workers, len(lanes))
+	}
+
+	totalWeight := 0
+	for _, l := range lanes {
+		totalWeight += l.weight
+	}
+
+	// One worker per lane, then the others by largest share of the remaining workers
+	counts := make([]int, len(lanes))
+	remainders := make([]int, len(lanes))
+	spare := workers - len(lanes)
+	assigned := 0
+	for i, l := range lanes {
+		share := spare * l.weight
+		counts[i] = 1 + share/totalWeight
+		remainders[i] = share % totalWeight
+		assigned += counts[i]
+	}
+	for ; assigned < workers; assigned++ {
+		best := 0
+		for i := range remainders {
+			if remainders[i] > remainders[best] {
+				best = i
+			}
+		}
+		counts[best]++
+		remainders[best] = -1
+	}
+	return counts, nil
+}
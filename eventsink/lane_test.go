@@ -0,0 +1,195 @@
+package eventsink_test
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/diskqueue"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+var _ = Describe("Priority lanes", func() {
+
+	Context("ParseLanes", func() {
+		It("returns no lanes for an empty string", func() {
+			lanes, err := eventsink.ParseLanes("")
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(lanes).To(BeEmpty())
+		})
+
+		It("parses a JSON list with defaults", func() {
+			lanes, err := eventsink.ParseLanes(`[
+				{"name":"logs","events":["LogMessage","Error"],"weight":3,"overflow_policy":"block"},
+				{"name":"metrics","events":["ValueMetric"],"queue_size":100}
+			]`)
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(lanes).To(Equal([]eventsink.Lane{
+				{Name: "logs", Events: []string{"LogMessage", "Error"}, Weight: 3, OverflowPolicy: eventsink.OverflowBlock},
+				{Name: "metrics", Events: []string{"ValueMetric"}, Weight: 1, QueueSize: 100},
+			}))
+		})
+
+		It("reads lanes from a file", func() {
+			file, _ := os.CreateTemp("", "lanes")
+			defer os.Remove(file.Name())
+			file.WriteString(`[{"name":"logs","events":["LogMessage"]}]`)
+			file.Close()
+
+			lanes, err := eventsink.ParseLanes(file.Name())
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(lanes).To(HaveLen(1))
+		})
+
+		It("rejects invalid lanes", func() {
+			for _, lanes := range []string{
+				`[{"events":["LogMessage"]}]`,
+				`[{"name":"logs"},{"name":"logs"}]`,
+				`[{"name":"logs","events":["NoSuchEvent"]}]`,
+				`[{"name":"logs","events":["LogMessage"]},{"name":"all","events":["LogMessage"]}]`,
+				`[{"name":"logs","weight":-1}]`,
+				`[{"name":"logs","overflow_policy":"ignore"}]`,
+				`not json`,
+			} {
+				_, err := eventsink.ParseLanes(lanes)
+				Ω(err).Should(HaveOccurred(), lanes)
+			}
+		})
+	})
+
+	Context("Splunk", func() {
+		var (
+			memSink *testing.MemorySinkMock
+			writers []*testing.EventWriterMock
+			config  *eventsink.SplunkConfig
+			rconfig *eventrouter.Config
+			router  eventrouter.Router
+		)
+
+		BeforeEach(func() {
+			router, memSink, rconfig = newRouter("LogMessage,ValueMetric")
+
+			writers = nil
+			for i := 0; i < 4; i++ {
+				writers = append(writers, &testing.EventWriterMock{})
+			}
+			config = newSinkConfig()
+			config.BatchSize = 1
+		})
+
+		route := func(eventType events.Envelope_EventType, count int) {
+			for i := 0; i < count; i++ {
+				envelope := &events.Envelope{EventType: &eventType}
+				if eventType == events.Envelope_LogMessage {
+					envelope.LogMessage = &events.LogMessage{Message: []byte("log")}
+				} else {
+					name, value := "cpu", 1.0
+					envelope.ValueMetric = &events.ValueMetric{Name: &name, Value: &value}
+				}
+				router.Route(envelope)
+			}
+		}
+
+		newSink := func() *eventsink.Splunk {
+			var sinkWriters []eventwriter.Writer
+			for _, w := range writers {
+				sinkWriters = append(sinkWriters, w)
+			}
+			return newTestSink(config, rconfig, sinkWriters...)
+		}
+
+		captured := func(from, to int) func() int {
+			return func() int {
+				count := 0
+				for _, w := range writers[from:to] {
+					count += len(w.CapturedEvents())
+				}
+				return count
+			}
+		}
+
+		It("splits the workers between the lanes by weight", func() {
+			config.Lanes = []eventsink.Lane{
+				{Name: "logs", Events: []string{"LogMessage"}, Weight: 3},
+				{Name: "metrics", Events: []string{"ValueMetric"}, Weight: 1},
+			}
+			sink := newSink()
+			Ω(sink.Open()).ShouldNot(HaveOccurred())
+
+			route(events.Envelope_ValueMetric, 10)
+			route(events.Envelope_LogMessage, 10)
+			writeEvents(sink, memSink)
+			Eventually(captured(0, 4)).Should(Equal(20))
+			sink.Close()
+
+			Expect(captured(0, 3)()).To(Equal(10))
+			Expect(captured(3, 4)()).To(Equal(10))
+			for _, event := range writers[3].CapturedEvents() {
+				Expect(event["sourcetype"]).To(Equal("cf:valuemetric"))
+			}
+		})
+
+		It("keeps draining logs while the metrics lane is flooded", func() {
+			config.Lanes = []eventsink.Lane{
+				{Name: "logs", Events: []string{"LogMessage"}, Weight: 3},
+				{Name: "metrics", Events: []string{"ValueMetric"}, QueueSize: 5},
+			}
+			release := make(chan struct{})
+			writers[3].PostBatchFn = func(events []map[string]interface{}) error {
+				<-release
+				return nil
+			}
+			sink := newSink()
+			sink.Open()
+
+			route(events.Envelope_ValueMetric, 20)
+			route(events.Envelope_LogMessage, 10)
+			writeEvents(sink, memSink)
+			Eventually(captured(0, 3)).Should(Equal(10))
+			Expect(sink.FirehoseDroppedEvents.Value()).To(BeNumerically(">", 0))
+
+			close(release)
+			sink.Close()
+		})
+
+		It("sends the event types of no lane to the last lane", func() {
+			config.Lanes = []eventsink.Lane{
+				{Name: "logs", Events: []string{"LogMessage"}, Weight: 3},
+				{Name: "others", Weight: 1},
+			}
+			sink := newSink()
+			sink.Open()
+
+			route(events.Envelope_ValueMetric, 5)
+			writeEvents(sink, memSink)
+			Eventually(captured(3, 4)).Should(Equal(5))
+			sink.Close()
+			Expect(captured(0, 3)()).To(BeZero())
+		})
+
+		It("needs a worker per lane", func() {
+			config.Lanes = []eventsink.Lane{{Name: "logs"}, {Name: "metrics"}}
+			writers = writers[:1]
+			sink := newSink()
+			Ω(sink.Open()).Should(HaveOccurred())
+		})
+
+		It("can't be used with the disk queue", func() {
+			dir, err := os.MkdirTemp("", "lanes")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			config.Queue, err = diskqueue.Open(&diskqueue.Config{Dir: dir, SegmentSize: 1024, SyncPolicy: diskqueue.SyncNever, Logger: lager.NewLogger("test")})
+			Ω(err).ShouldNot(HaveOccurred())
+			defer config.Queue.Close()
+
+			config.Lanes = []eventsink.Lane{{Name: "logs"}, {Name: "metrics"}}
+			Ω(newSink().Open()).Should(HaveOccurred())
+		})
+	})
+})
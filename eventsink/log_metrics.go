@@ -0,0 +1,98 @@
+package eventsink
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	fevents "github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+)
+
+type logMetricsKey struct {
+	rule  string
+	appID string
+}
+
+type logMetricsBucket struct {
+	rule       *fevents.LogMetricRule
+	dimensions map[string]interface{}
+	count      uint64
+	sum        float64
+	min        float64
+	max        float64
+}
+
+// logMetrics rolls datapoints extracted from LogMessage events up per rule and app
+type logMetrics struct {
+	rules   fevents.LogMetricRules
+	mutex   sync.Mutex
+	buckets map[logMetricsKey]*logMetricsBucket
+}
+
+func newLogMetrics(rules fevents.LogMetricRules) *logMetrics {
+	return &logMetrics{
+		rules:   rules,
+		buckets: make(map[logMetricsKey]*logMetricsBucket),
+	}
+}
+
+// add accounts the datapoints of a parsed LogMessage event into the current window
+func (l *logMetrics) add(fields map[string]interface{}) {
+	msg, _ := fields["msg"].(string)
+	if msg == "" {
+		return
+	}
+
+	for _, rule := range l.rules {
+		value, ok := rule.Extract(msg)
+		if !ok {
+			continue
+		}
+
+		key := logMetricsKey{rule: rule.Name, appID: fmt.Sprint(fields["cf_app_id"])}
+		l.mutex.Lock()
+		bucket, ok := l.buckets[key]
+		if !ok {
+			bucket = &logMetricsBucket{
+				rule:       rule,
+				dimensions: dimensionsOf(fields, appDimensions),
+				min:        math.Inf(1),
+				max:        math.Inf(-1),
+			}
+			l.buckets[key] = bucket
+		}
+		bucket.count++
+		bucket.sum += value
+		bucket.min = math.Min(bucket.min, value)
+		bucket.max = math.Max(bucket.max, value)
+		l.mutex.Unlock()
+	}
+}
+
+// flush returns one metric event per rule and app of the window and starts a new window
+func (l *logMetrics) flush(now time.Time, host string) []map[string]interface{} {
+	l.mutex.Lock()
+	buckets := l.buckets
+	l.buckets = make(map[logMetricsKey]*logMetricsBucket)
+	l.mutex.Unlock()
+
+	timestamp := utils.NanoSecondsToSeconds(now.UnixNano())
+	metrics := make([]map[string]interface{}, 0, len(buckets))
+	for _, bucket := range buckets {
+		fields := bucket.dimensions
+		name := metricPrefix + bucket.rule.Name
+		if bucket.rule.Type == fevents.LogMetricCounter {
+			fields[name] = bucket.count
+		} else {
+			fields[name+".count"] = bucket.count
+			fields[name+".sum"] = bucket.sum
+			fields[name+".min"] = bucket.min
+			fields[name+".max"] = bucket.max
+			fields[name+".avg"] = bucket.sum / float64(bucket.count)
+		}
+		metrics = append(metrics, metricEvent(timestamp, host, "cf:logmetrics", fields))
+	}
+	return metrics
+}
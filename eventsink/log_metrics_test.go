@@ -0,0 +1,61 @@
+package eventsink_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	fevents "github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+)
+
+var _ = Describe("Log metrics", func() {
+	It("aggregates datapoints extracted from log messages", func() {
+		router, memSink, rconfig := newRouter("LogMessage")
+		rconfig.AddAppName = true
+
+		appId := "f964a41c-76ac-42c1-b2ba-663da3ec22d5"
+		for _, msg := range []string{"processed 512 orders in 3.2s", "processed 8 orders in 0.8s", "started"} {
+			event := logMessage(msg)
+			event.LogMessage.AppId = &appId
+			router.Route(event)
+		}
+
+		rules, err := fevents.ParseLogMetricRules(`[
+			{"name":"orders.batches","type":"counter","regex":"processed"},
+			{"name":"orders.processed","type":"value","regex":"processed (\\d+) orders"}
+		]`)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		eventWriter := &testing.EventWriterMock{}
+		metricWriter := &testing.EventWriterMock{}
+		config := newSinkConfig()
+		config.LogMetricRules = rules
+		config.LogMetricsWindow = time.Hour
+		config.MetricWriter = metricWriter
+		sink := newTestSink(config, rconfig, eventWriter)
+		sink.Open()
+		writeEvents(sink, memSink)
+		sink.Close()
+
+		Expect(eventWriter.CapturedEvents()).To(HaveLen(3))
+
+		merged := map[string]interface{}{}
+		for _, event := range metricWriter.CapturedEvents() {
+			Expect(event["sourcetype"]).To(Equal("cf:logmetrics"))
+			for k, v := range event["fields"].(map[string]interface{}) {
+				merged[k] = v
+			}
+		}
+		Expect(metricWriter.CapturedEvents()).To(HaveLen(2))
+		Expect(merged["cf_app_id"]).To(Equal(appId))
+		Expect(merged["cf_app_name"]).To(Equal("testing-app"))
+		Expect(merged["metric_name:orders.batches"]).To(Equal(uint64(2)))
+		Expect(merged["metric_name:orders.processed.count"]).To(Equal(uint64(2)))
+		Expect(merged["metric_name:orders.processed.sum"]).To(Equal(float64(520)))
+		Expect(merged["metric_name:orders.processed.min"]).To(Equal(float64(8)))
+		Expect(merged["metric_name:orders.processed.max"]).To(Equal(float64(512)))
+		Expect(merged["metric_name:orders.processed.avg"]).To(Equal(float64(260)))
+	})
+})
@@ -0,0 +1,80 @@
+package eventsink
+
+import (
+	fevents "github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
+)
+
+// limitMessage sanitizes the message of the event and applies the oversized
+// message policy. It returns the events to send: none when the message is
+// dropped, one per part when it is split.
+func (s *Splunk) limitMessage(fields map[string]interface{}) []map[string]interface{} {
+	msg, ok := fields["msg"].(string)
+	if !ok {
+		return []map[string]interface{}{fields}
+	}
+
+	if s.config.SanitizeMessages {
+		msg = fevents.SanitizeMessage(msg)
+		fields["msg"] = msg
+	}
+	if s.config.MaxMessageSize <= 0 || len(msg) <= s.config.MaxMessageSize {
+		return []map[string]interface{}{fields}
+	}
+
+	s.OversizedMessages.Add(1)
+	switch s.config.OversizedMessagePolicy {
+	case fevents.OversizedDrop:
+		return nil
+	case fevents.OversizedSplit:
+		parts := fevents.SplitMessage(msg, s.config.MaxMessageSize)
+		events := make([]map[string]interface{}, 0, len(parts))
+		for i, part := range parts {
+			partFields := make(map[string]interface{}, len(fields)+2)
+			for k, v := range fields {
+				partFields[k] = copyValue(v)
+			}
+			partFields["msg"] = part
+			partFields["msg_part"] = i + 1
+			partFields["msg_parts"] = len(parts)
+			events = append(events, partFields)
+		}
+		return events
+	default:
+		fields["msg"] = fevents.TruncateMessage(msg, s.config.MaxMessageSize)
+		fields["truncated"] = true
+		return []map[string]interface{}{fields}
+	}
+}
+
+// copyValue copies the maps and lists of v so that the parts of a split
+// message can be modified independently
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for k, value := range v {
+			copied[k] = copyValue(value)
+		}
+		return copied
+	case map[string]string:
+		copied := make(map[string]string, len(v))
+		for k, value := range v {
+			copied[k] = value
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, value := range v {
+			copied[i] = copyValue(value)
+		}
+		return copied
+	case []map[string]string:
+		copied := make([]map[string]string, len(v))
+		for i, value := range v {
+			copied[i] = copyValue(value).(map[string]string)
+		}
+		return copied
+	default:
+		return v
+	}
+}
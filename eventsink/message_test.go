@@ -0,0 +1,97 @@
+package eventsink_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
+	fevents "github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+)
+
+var _ = Describe("Oversized messages", func() {
+	var (
+		memSink     *testing.MemorySinkMock
+		eventWriter *testing.EventWriterMock
+		config      *eventsink.SplunkConfig
+		rconfig     *eventrouter.Config
+	)
+
+	BeforeEach(func() {
+		memSink, rconfig = routeLogMessages("short", strings.Repeat("x", 25))
+
+		eventWriter = &testing.EventWriterMock{}
+		config = newSinkConfig()
+		config.MaxMessageSize = 10
+	})
+
+	messages := func() []map[string]interface{} {
+		sink := newTestSink(config, rconfig, eventWriter)
+		sink.Open()
+		writeEvents(sink, memSink)
+		sink.Close()
+
+		var fields []map[string]interface{}
+		for _, event := range eventWriter.CapturedEvents() {
+			fields = append(fields, event["event"].(map[string]interface{}))
+		}
+		return fields
+	}
+
+	It("truncates oversized messages", func() {
+		config.OversizedMessagePolicy = fevents.OversizedTruncate
+		fields := messages()
+		Expect(fields).To(HaveLen(2))
+		Expect(fields[0]["msg"]).To(Equal("short"))
+		Expect(fields[0]).NotTo(HaveKey("truncated"))
+		Expect(fields[1]["msg"]).To(Equal("xxxxxxxxxx"))
+		Expect(fields[1]["truncated"]).To(BeTrue())
+	})
+
+	It("splits oversized messages in numbered parts", func() {
+		config.OversizedMessagePolicy = fevents.OversizedSplit
+		fields := messages()
+		Expect(fields).To(HaveLen(4))
+		Expect(fields[3]["msg"]).To(Equal("xxxxx"))
+		Expect(fields[3]["msg_part"]).To(Equal(3))
+		Expect(fields[3]["msg_parts"]).To(Equal(3))
+	})
+
+	It("gives each part its own copy of nested fields", func() {
+		dir, err := os.MkdirTemp("", "message")
+		Ω(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "types.json")
+		Ω(os.WriteFile(file, []byte(`{"LogMessage": {"team": {"name": "diego"}, "zones": ["z1"]}}`), 0600)).ShouldNot(HaveOccurred())
+		config.Lookups, err = fevents.NewLookups(`[{"file":"` + file + `","field":"event_type"}]`)
+		Ω(err).ShouldNot(HaveOccurred())
+		config.OversizedMessagePolicy = fevents.OversizedSplit
+
+		fields := messages()
+		Expect(fields).To(HaveLen(4))
+		fields[1]["team"].(map[string]interface{})["name"] = "cc"
+		fields[1]["zones"].([]interface{})[0] = "z2"
+		Expect(fields[2]["team"]).To(Equal(map[string]interface{}{"name": "diego"}))
+		Expect(fields[2]["zones"]).To(Equal([]interface{}{"z1"}))
+	})
+
+	It("drops oversized messages", func() {
+		config.OversizedMessagePolicy = fevents.OversizedDrop
+		fields := messages()
+		Expect(fields).To(HaveLen(1))
+		Expect(fields[0]["msg"]).To(Equal("short"))
+	})
+
+	It("sanitizes messages", func() {
+		config.MaxMessageSize = 0
+		config.SanitizeMessages = true
+		memSink.Events[0].LogMessage.Message = []byte("bad\x00\xffmsg")
+		fields := messages()
+		Expect(fields[0]["msg"]).To(Equal("bad�msg"))
+	})
+})
@@ -0,0 +1,134 @@
+package eventsink
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// metricsPartition is the batch partition of events sent to the metrics index
+const metricsPartition = "\x00metrics"
+
+// Measurements of ContainerMetric events, sent as container.<field>
+var containerMeasurements = []string{
+	"cpu_percentage",
+	"disk_bytes",
+	"disk_bytes_quota",
+	"memory_bytes",
+	"memory_bytes_quota",
+	"memory_percentage",
+	"disk_percentage",
+	"cpu_entitlement_percentage",
+}
+
+// Fields which are neither measurements nor dimensions
+var nonDimensionFields = []string{"timestamp", "event_type", "tags", "msg", "cf_ignored_app"}
+
+func isPlatformMetric(eventType events.Envelope_EventType) bool {
+	switch eventType {
+	case events.Envelope_ValueMetric, events.Envelope_CounterEvent, events.Envelope_ContainerMetric:
+		return true
+	}
+	return false
+}
+
+// buildMetricEvent builds a HEC metric event out of a ValueMetric, CounterEvent
+// or ContainerMetric. The remaining fields, tags and extra fields are sent as
+// dimensions. It returns nil when the event has no numeric measurement.
+func (s *Splunk) buildMetricEvent(fields map[string]interface{}) map[string]interface{} {
+	measurements := make(map[string]interface{})
+	switch fields["event_type"] {
+	case events.Envelope_ValueMetric.String():
+		// NaN and infinite values are converted to strings by the parser
+		if value, ok := fields["value"].(float64); ok {
+			measurements[fmt.Sprint(fields["name"])] = value
+		}
+		delete(fields, "value")
+		delete(fields, "name")
+	case events.Envelope_CounterEvent.String():
+		name := fmt.Sprint(fields["name"])
+		measurements[name+".delta"] = fields["delta"]
+		measurements[name+".total"] = fields["total"]
+		if rate, ok := fields["rate"]; ok {
+			measurements[name+".rate"] = rate
+		}
+		delete(fields, "delta")
+		delete(fields, "total")
+		delete(fields, "rate")
+		delete(fields, "counter_reset")
+		delete(fields, "name")
+	case events.Envelope_ContainerMetric.String():
+		for _, field := range containerMeasurements {
+			if v, ok := fields[field]; ok {
+				measurements["container."+field] = v
+				delete(fields, field)
+			}
+		}
+	}
+	if len(measurements) == 0 {
+		return nil
+	}
+
+	var timestamp string
+	if v, ok := fields["timestamp"].(int64); ok {
+		timestamp = utils.NanoSecondsToSeconds(v)
+	} else {
+		timestamp = utils.NanoSecondsToSeconds(time.Now().UnixNano())
+	}
+	sourcetype := fmt.Sprintf("cf:%s", strings.ToLower(fmt.Sprint(fields["event_type"])))
+
+	dimensions := make(map[string]interface{})
+	if tags, ok := fields["tags"].(map[string]string); ok {
+		for k, v := range tags {
+			dimensions[k] = v
+		}
+	}
+	for _, field := range nonDimensionFields {
+		delete(fields, field)
+	}
+	for k, v := range fields {
+		if !strings.HasPrefix(k, "info_splunk_") {
+			addDimension(dimensions, k, v)
+		}
+	}
+	for k, v := range s.config.ExtraFields {
+		dimensions[k] = v
+	}
+	for name, value := range measurements {
+		dimensions[metricPrefix+name] = value
+	}
+
+	return map[string]interface{}{
+		"time":       timestamp,
+		"host":       fields["ip"],
+		"source":     fields["job"],
+		"sourcetype": sourcetype,
+		"event":      "metric",
+		"fields":     dimensions,
+	}
+}
+
+// addDimension adds the field as flat dimensions. HEC metric dimensions are
+// strings or numbers: nested maps, e.g. of lookups or transforms, are
+// flattened into dotted names, booleans are sent as strings and other values,
+// e.g. lists, are left out.
+func addDimension(dimensions map[string]interface{}, name string, value interface{}) {
+	switch v := value.(type) {
+	case string, float64, float32, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		dimensions[name] = v
+	case bool:
+		dimensions[name] = strconv.FormatBool(v)
+	case map[string]interface{}:
+		for k, nested := range v {
+			addDimension(dimensions, name+"."+k, nested)
+		}
+	case map[string]string:
+		for k, nested := range v {
+			dimensions[name+"."+k] = nested
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package eventsink_test
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
+	fevents "github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+var _ = Describe("Native metrics", func() {
+	var (
+		memSink      *testing.MemorySinkMock
+		router       eventrouter.Router
+		eventWriter  *testing.EventWriterMock
+		metricWriter *testing.EventWriterMock
+		config       *eventsink.SplunkConfig
+		rconfig      *eventrouter.Config
+		origin       = "rep"
+		ip           = "10.0.0.1"
+		job          = "diego_cell"
+	)
+
+	envelope := func(eventType events.Envelope_EventType) *events.Envelope {
+		return &events.Envelope{
+			EventType: &eventType,
+			Origin:    &origin,
+			Ip:        &ip,
+			Job:       &job,
+			Tags:      map[string]string{"az": "z1"},
+		}
+	}
+
+	metricFields := func() []map[string]interface{} {
+		var fields []map[string]interface{}
+		for _, event := range metricWriter.CapturedEvents() {
+			Expect(event["event"]).To(Equal("metric"))
+			fields = append(fields, event["fields"].(map[string]interface{}))
+		}
+		return fields
+	}
+
+	BeforeEach(func() {
+		router, memSink, rconfig = newRouter("ValueMetric,CounterEvent,ContainerMetric,LogMessage")
+		rconfig.AddTags = true
+
+		eventWriter = &testing.EventWriterMock{}
+		metricWriter = &testing.EventWriterMock{}
+		config = newSinkConfig()
+		config.ExtraFields = map[string]string{"env": "dev"}
+		config.NativeMetrics = true
+		config.MetricWriter = metricWriter
+	})
+
+	run := func() {
+		sink := newTestSink(config, rconfig, eventWriter)
+		sink.Open()
+		writeEvents(sink, memSink)
+		sink.Close()
+	}
+
+	It("sends ValueMetric in HEC metric format", func() {
+		e := envelope(events.Envelope_ValueMetric)
+		name, unit, value := "numCPUS", "count", 4.0
+		e.ValueMetric = &events.ValueMetric{Name: &name, Unit: &unit, Value: &value}
+		router.Route(e)
+		run()
+
+		Expect(eventWriter.CapturedEvents()).To(BeEmpty())
+		Expect(metricWriter.CapturedEvents()).To(HaveLen(1))
+		event := metricWriter.CapturedEvents()[0]
+		Expect(event["host"]).To(Equal(ip))
+		Expect(event["source"]).To(Equal(job))
+		Expect(event["sourcetype"]).To(Equal("cf:valuemetric"))
+
+		fields := metricFields()[0]
+		Expect(fields["metric_name:numCPUS"]).To(Equal(4.0))
+		Expect(fields["unit"]).To(Equal("count"))
+		Expect(fields["origin"]).To(Equal(origin))
+		Expect(fields["az"]).To(Equal("z1"))
+		Expect(fields["env"]).To(Equal("dev"))
+		Expect(fields).NotTo(HaveKey("name"))
+		Expect(fields).NotTo(HaveKey("tags"))
+		Expect(fields).NotTo(HaveKey("event_type"))
+	})
+
+	It("flattens nested fields into scalar dimensions", func() {
+		dir, err := os.MkdirTemp("", "lookups")
+		Ω(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "origins.json")
+		Ω(os.WriteFile(file, []byte(`{"rep": {"team": {"name": "diego", "oncall": true}, "zones": ["z1", "z2"], "tier": 1}}`), 0600)).ShouldNot(HaveOccurred())
+		config.Lookups, err = fevents.NewLookups(`[{"file":"` + file + `","field":"origin"}]`)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		e := envelope(events.Envelope_ValueMetric)
+		name, unit, value := "numCPUS", "count", 4.0
+		e.ValueMetric = &events.ValueMetric{Name: &name, Unit: &unit, Value: &value}
+		router.Route(e)
+		run()
+
+		fields := metricFields()[0]
+		Expect(fields["team.name"]).To(Equal("diego"))
+		Expect(fields["team.oncall"]).To(Equal("true"))
+		Expect(fields["tier"]).To(Equal(1.0))
+		Expect(fields).NotTo(HaveKey("team"))
+		Expect(fields).NotTo(HaveKey("zones"))
+		Expect(fields["metric_name:numCPUS"]).To(Equal(4.0))
+	})
+
+	It("drops ValueMetric without numeric value", func() {
+		e := envelope(events.Envelope_ValueMetric)
+		name, value := "numCPUS", math.NaN()
+		e.ValueMetric = &events.ValueMetric{Name: &name, Value: &value}
+		router.Route(e)
+		run()
+
+		Expect(metricWriter.CapturedEvents()).To(BeEmpty())
+	})
+
+	It("sends CounterEvent delta and total", func() {
+		e := envelope(events.Envelope_CounterEvent)
+		name, delta, total := "requests", uint64(3), uint64(42)
+		e.CounterEvent = &events.CounterEvent{Name: &name, Delta: &delta, Total: &total}
+		router.Route(e)
+		run()
+
+		fields := metricFields()[0]
+		Expect(fields["metric_name:requests.delta"]).To(Equal(delta))
+		Expect(fields["metric_name:requests.total"]).To(Equal(total))
+	})
+
+	It("sends all ContainerMetric measurements in one datapoint", func() {
+		e := envelope(events.Envelope_ContainerMetric)
+		appId, index, cpu, memory := "f964a41c-76ac-42c1-b2ba-663da3ec22d5", int32(1), 12.5, uint64(1024)
+		e.ContainerMetric = &events.ContainerMetric{ApplicationId: &appId, InstanceIndex: &index, CpuPercentage: &cpu, MemoryBytes: &memory}
+		router.Route(e)
+		run()
+
+		fields := metricFields()[0]
+		Expect(fields["metric_name:container.cpu_percentage"]).To(Equal(cpu))
+		Expect(fields["metric_name:container.memory_bytes"]).To(Equal(memory))
+		Expect(fields["cf_app_id"]).To(Equal(appId))
+		Expect(fields["instance_index"]).To(Equal(index))
+	})
+
+	It("keeps other events in the event index", func() {
+		e := envelope(events.Envelope_LogMessage)
+		e.LogMessage = &events.LogMessage{Message: []byte("hello")}
+		router.Route(e)
+		run()
+
+		Expect(eventWriter.CapturedEvents()).To(HaveLen(1))
+		Expect(metricWriter.CapturedEvents()).To(BeEmpty())
+	})
+
+	It("sends events when disabled", func() {
+		config.NativeMetrics = false
+		e := envelope(events.Envelope_ValueMetric)
+		name, value := "numCPUS", 4.0
+		e.ValueMetric = &events.ValueMetric{Name: &name, Value: &value}
+		router.Route(e)
+		run()
+
+		Expect(eventWriter.CapturedEvents()).To(HaveLen(1))
+		Expect(metricWriter.CapturedEvents()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,117 @@
+package eventsink
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/monitoring"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// instanceKey returns the app instance of the event, "" for events of no app
+// instance
+func instanceKey(msg *events.Envelope) string {
+	switch msg.GetEventType() {
+	case events.Envelope_LogMessage:
+		logMessage := msg.GetLogMessage()
+		if logMessage.GetAppId() != "" {
+			return logMessage.GetAppId() + "/" + logMessage.GetSourceInstance()
+		}
+	case events.Envelope_ContainerMetric:
+		containerMetric := msg.GetContainerMetric()
+		if containerMetric.GetApplicationId() != "" {
+			return containerMetric.GetApplicationId() + "/" + strconv.Itoa(int(containerMetric.GetInstanceIndex()))
+		}
+	case events.Envelope_HttpStartStop:
+		httpStartStop := msg.GetHttpStartStop()
+		if httpStartStop.GetApplicationId() != nil {
+			return utils.FormatUUID(httpStartStop.GetApplicationId()) + "/" + strconv.Itoa(int(httpStartStop.GetInstanceIndex()))
+		}
+	}
+	return ""
+}
+
+// order gives each of the workers of the lane its own queue. Open starts the
+// workers on these queues.
+func (l *lane) order(workers int) {
+	queueSize := cap(l.events) / workers
+	if queueSize == 0 {
+		queueSize = 1
+	}
+	l.workerQueues = make([]chan *events.Envelope, workers)
+	for i := range l.workerQueues {
+		l.workerQueues[i] = make(chan *events.Envelope, queueSize)
+	}
+	l.dispatched = make([]uint64, workers)
+}
+
+// dispatch hands the events of the lane over to the queues of its workers.
+// The events of an app instance always go to the same worker so that they are
+// sent in order, the other events go round robin.
+func (s *Splunk) dispatch(l *lane) {
+	defer s.wg.Done()
+
+	next := 0
+	for msg := range l.events {
+		var worker int
+		if key := instanceKey(msg); key != "" {
+			hash := fnv.New32a()
+			hash.Write([]byte(key))
+			worker = int(hash.Sum32() % uint32(len(l.workerQueues)))
+		} else {
+			worker = next
+			next = (next + 1) % len(l.workerQueues)
+		}
+		atomic.AddUint64(&l.dispatched[worker], 1)
+		l.workerQueues[worker] <- msg
+	}
+
+	// events chan has closed, the workers drain their queues and exit
+	for _, queue := range l.workerQueues {
+		close(queue)
+	}
+}
+
+// imbalance returns the ratio of the events of the busiest worker of the lane
+// to the average events per worker since it was last read, 1 being balanced
+func (l *lane) imbalance() float64 {
+	var total, busiest uint64
+	for i := range l.dispatched {
+		n := atomic.SwapUint64(&l.dispatched[i], 0)
+		total += n
+		if n > busiest {
+			busiest = n
+		}
+	}
+	if total == 0 {
+		return 1
+	}
+	return float64(busiest) * float64(len(l.dispatched)) / float64(total)
+}
+
+// registerOrderedMetrics reports the imbalance of the workers of the lanes
+// and the fill level of the fullest worker queue
+func (s *Splunk) registerOrderedMetrics() {
+	monitoring.RegisterFunc("nozzle.ordered.imbalance", func() interface{} {
+		imbalance := 0.0
+		for _, l := range s.lanes {
+			if v := l.imbalance(); v > imbalance {
+				imbalance = v
+			}
+		}
+		return imbalance
+	})
+	monitoring.RegisterFunc("nozzle.ordered.queue.max.percentage", func() interface{} {
+		fullest := 0.0
+		for _, l := range s.lanes {
+			for _, queue := range l.workerQueues {
+				if v := float64(len(queue)) / float64(cap(queue)) * 100.0; v > fullest {
+					fullest = v
+				}
+			}
+		}
+		return fullest
+	})
+}
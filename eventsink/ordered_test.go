@@ -0,0 +1,90 @@
+package eventsink_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+)
+
+var _ = Describe("Ordered delivery", func() {
+	var (
+		memSink *testing.MemorySinkMock
+		writers []*testing.EventWriterMock
+		config  *eventsink.SplunkConfig
+		rconfig *eventrouter.Config
+	)
+
+	BeforeEach(func() {
+		var router eventrouter.Router
+		router, memSink, rconfig = newRouter("LogMessage")
+
+		// Lines of 3 app instances, interleaved
+		for i := 0; i < 20; i++ {
+			for _, instance := range []string{"app1/0", "app1/1", "app2/0"} {
+				appID, sourceInstance := instance[:4], instance[5:]
+				envelope := logMessage(fmt.Sprintf("%s line %02d", instance, i))
+				envelope.LogMessage.AppId = &appID
+				envelope.LogMessage.SourceInstance = &sourceInstance
+				router.Route(envelope)
+			}
+		}
+
+		writers = nil
+		for i := 0; i < 4; i++ {
+			writers = append(writers, &testing.EventWriterMock{})
+		}
+		config = newSinkConfig()
+		config.QueueSize = 10
+		config.BatchSize = 3
+		config.OverflowPolicy = eventsink.OverflowBlock
+		config.OrderedDelivery = true
+	})
+
+	newSink := func() *eventsink.Splunk {
+		var sinkWriters []eventwriter.Writer
+		for _, writer := range writers {
+			sinkWriters = append([]eventwriter.Writer{writer}, sinkWriters...)
+		}
+		return newTestSink(config, rconfig, sinkWriters...)
+	}
+
+	It("sends the events of an app instance with one worker, in order", func() {
+		sink := newSink()
+		Expect(sink.Open()).To(Succeed())
+		writeEvents(sink, memSink)
+		sink.Close()
+
+		lines := make(map[string][]string)
+		workers := make(map[string]int)
+		for i, writer := range writers {
+			for _, event := range writer.CapturedEvents() {
+				msg := event["event"].(map[string]interface{})["msg"].(string)
+				instance := msg[:6]
+				lines[instance] = append(lines[instance], msg)
+				if worker, ok := workers[instance]; ok {
+					Expect(worker).To(Equal(i), "events of %s sent by several workers", instance)
+				}
+				workers[instance] = i
+			}
+		}
+
+		Expect(lines).To(HaveLen(3))
+		for instance, msgs := range lines {
+			Expect(msgs).To(HaveLen(20))
+			for i, msg := range msgs {
+				Expect(msg).To(Equal(fmt.Sprintf("%s line %02d", instance, i)))
+			}
+		}
+	})
+
+	It("requires parsing in the HEC workers", func() {
+		config.ParseWorkers = 2
+		Expect(newSink().Open()).NotTo(Succeed())
+	})
+})
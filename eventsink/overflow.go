@@ -0,0 +1,90 @@
+package eventsink
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/diskqueue"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// Overflow policies of a full queue
+const (
+	OverflowDropNewest = "drop-newest"
+	OverflowDropOldest = "drop-oldest"
+	OverflowBlock      = "block"
+)
+
+// diskQueueRetryInterval is how often a blocked write retries a full disk queue
+const diskQueueRetryInterval = 10 * time.Millisecond
+
+// enqueue puts the event on the in-memory queue of its lane and applies the
+// overflow policy of the lane when the queue is full. Blocked writes return
+// on Stop. It returns false when the event is dropped.
+func (s *Splunk) enqueue(msg *events.Envelope) bool {
+	l := s.laneOf(msg.GetEventType())
+	select {
+	case l.events <- msg:
+		return true
+	default:
+	}
+
+	switch l.overflowPolicy {
+	case OverflowDropOldest:
+		for {
+			select {
+			case l.events <- msg:
+				return true
+			case <-l.events:
+				s.FirehoseDroppedEvents.Add(1)
+				atomic.AddUint64(&s.report.QueueDropped, 1)
+			}
+		}
+	case OverflowBlock:
+		var deadline <-chan time.Time
+		if s.config.OverflowMaxWait > 0 {
+			timer := time.NewTimer(s.config.OverflowMaxWait)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+		select {
+		case l.events <- msg:
+			return true
+		case <-deadline:
+			return false
+		case <-s.stopping:
+			return false
+		}
+	}
+	return false
+}
+
+// push appends the event to the disk queue. Blocking policies wait for the
+// consumers to free space until Stop, the oldest events can't be dropped from disk so
+// drop-oldest drops the newest. It returns false when the event is dropped.
+func (s *Splunk) push(data []byte) bool {
+	err := s.config.Queue.Push(data)
+	if err != diskqueue.ErrFull || s.config.OverflowPolicy != OverflowBlock {
+		return err == nil
+	}
+
+	var deadline <-chan time.Time
+	if s.config.OverflowMaxWait > 0 {
+		timer := time.NewTimer(s.config.OverflowMaxWait)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	ticker := time.NewTicker(diskQueueRetryInterval)
+	defer ticker.Stop()
+	for err == diskqueue.ErrFull {
+		select {
+		case <-ticker.C:
+			err = s.config.Queue.Push(data)
+		case <-deadline:
+			return false
+		case <-s.stopping:
+			return false
+		}
+	}
+	return err == nil
+}
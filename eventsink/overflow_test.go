@@ -0,0 +1,173 @@
+package eventsink_test
+
+import (
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/diskqueue"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+)
+
+var _ = Describe("Overflow policy", func() {
+	var (
+		memSink     *testing.MemorySinkMock
+		eventWriter *testing.EventWriterMock
+		config      *eventsink.SplunkConfig
+		rconfig     *eventrouter.Config
+		sink        *eventsink.Splunk
+	)
+
+	BeforeEach(func() {
+		memSink, rconfig = routeLogMessages("first", "second")
+
+		eventWriter = &testing.EventWriterMock{}
+		config = newSinkConfig()
+		config.QueueSize = 1
+		config.BatchSize = 1
+	})
+
+	newSink := func() *eventsink.Splunk {
+		return newTestSink(config, rconfig, eventWriter)
+	}
+
+	messages := func() []string {
+		var msgs []string
+		for _, event := range eventWriter.CapturedEvents() {
+			msgs = append(msgs, event["event"].(map[string]interface{})["msg"].(string))
+		}
+		return msgs
+	}
+
+	// writeAll writes the events before the consumers start so that the queue overflows
+	writeAll := func() {
+		writeEvents(sink, memSink)
+		sink.Open()
+		Eventually(messages).ShouldNot(BeEmpty())
+		sink.Close()
+	}
+
+	It("drops the newest events by default", func() {
+		sink = newSink()
+		writeAll()
+		Expect(messages()).To(Equal([]string{"first"}))
+		Expect(sink.FirehoseDroppedEvents.Value()).To(Equal(uint64(1)))
+	})
+
+	It("drops the oldest events", func() {
+		config.OverflowPolicy = eventsink.OverflowDropOldest
+		sink = newSink()
+		writeAll()
+		Expect(messages()).To(Equal([]string{"second"}))
+		Expect(sink.FirehoseDroppedEvents.Value()).To(Equal(uint64(1)))
+	})
+
+	It("drops events after the maximum wait", func() {
+		config.OverflowPolicy = eventsink.OverflowBlock
+		config.OverflowMaxWait = 50 * time.Millisecond
+		sink = newSink()
+
+		start := time.Now()
+		writeAll()
+		Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+		Expect(messages()).To(Equal([]string{"first"}))
+		Expect(sink.FirehoseDroppedEvents.Value()).To(Equal(uint64(1)))
+	})
+
+	It("blocks until there is room", func() {
+		config.OverflowPolicy = eventsink.OverflowBlock
+		sink = newSink()
+
+		sink.Write(memSink.Events[0])
+		written := make(chan struct{})
+		go func() {
+			sink.Write(memSink.Events[1])
+			close(written)
+		}()
+		Consistently(written, 100*time.Millisecond).ShouldNot(BeClosed())
+
+		sink.Open()
+		Eventually(written).Should(BeClosed())
+		Eventually(messages).Should(Equal([]string{"first", "second"}))
+		sink.Close()
+		Expect(sink.FirehoseDroppedEvents.Value()).To(BeZero())
+	})
+
+	It("releases the blocked writes on Stop", func() {
+		config.OverflowPolicy = eventsink.OverflowBlock
+		sink = newSink()
+
+		sink.Write(memSink.Events[0])
+		written := make(chan struct{})
+		go func() {
+			sink.Write(memSink.Events[1])
+			close(written)
+		}()
+		Consistently(written, 100*time.Millisecond).ShouldNot(BeClosed())
+
+		sink.Stop()
+		Eventually(written).Should(BeClosed())
+		Expect(sink.FirehoseDroppedEvents.Value()).To(Equal(uint64(1)))
+		sink.Open()
+		sink.Close()
+		Expect(messages()).To(Equal([]string{"first"}))
+	})
+
+	Context("with a disk queue", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = os.MkdirTemp("", "overflow")
+			Ω(err).ShouldNot(HaveOccurred())
+			config.Queue, err = diskqueue.Open(&diskqueue.Config{
+				Dir:         dir,
+				SegmentSize: 1024,
+				MaxSize:     1,
+				SyncPolicy:  diskqueue.SyncNever,
+				Logger:      lager.NewLogger("test"),
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("drops events after the maximum wait", func() {
+			config.OverflowPolicy = eventsink.OverflowBlock
+			config.OverflowMaxWait = 50 * time.Millisecond
+			sink = newSink()
+			sink.Open()
+
+			start := time.Now()
+			sink.Write(memSink.Events[0])
+			Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+			sink.Close()
+			Expect(sink.FirehoseDroppedEvents.Value()).To(Equal(uint64(1)))
+		})
+
+		It("releases the blocked writes on Stop", func() {
+			config.OverflowPolicy = eventsink.OverflowBlock
+			sink = newSink()
+
+			written := make(chan struct{})
+			go func() {
+				sink.Write(memSink.Events[0])
+				close(written)
+			}()
+			Consistently(written, 100*time.Millisecond).ShouldNot(BeClosed())
+
+			sink.Stop()
+			Eventually(written).Should(BeClosed())
+			Expect(sink.FirehoseDroppedEvents.Value()).To(Equal(uint64(1)))
+			sink.Open()
+			sink.Close()
+		})
+	})
+})
@@ -0,0 +1,219 @@
+package eventsink
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// pendingBatch is a batch waiting in the send queue of a lane
+type pendingBatch struct {
+	dest    destination
+	events  []map[string]interface{}
+	records []uint64 // disk queue records of the events, released once sent
+}
+
+// sender sends batches with the writers of a HEC worker
+type sender struct {
+	s *Splunk
+	// Writers are per tenant, "" being the default HEC host and token and
+	// metricsPartition the metrics index
+	writers map[string]eventwriter.Writer
+	sizerOf func(tenant string) *batchSizer
+}
+
+func (s *Splunk) newSender(writer eventwriter.Writer, sizerOf func(tenant string) *batchSizer) *sender {
+	writers := map[string]eventwriter.Writer{"": writer}
+	if s.config.MetricWriter != nil {
+		writers[metricsPartition] = s.config.MetricWriter
+	}
+	return &sender{s: s, writers: writers, sizerOf: sizerOf}
+}
+
+func (snd *sender) send(batch pendingBatch) {
+	writer := snd.s.tenantWriter(snd.writers, batch.dest.tenant)
+	if snd.s.config.AdaptiveBatching {
+		writer = &sizedWriter{Writer: writer, sizer: snd.sizerOf(batch.dest.tenant)}
+	}
+	start := time.Now()
+	snd.s.indexEvents(batch.dest, writer, batch.events)
+	snd.s.sendLatency.observe(time.Since(start))
+	snd.s.acker.done(batch.records...)
+}
+
+// newSizers returns the batch sizers of tenants. With adaptive batching, each
+// tenant writer of a HEC worker, or of a lane when stages are decoupled, has
+// its own batch size.
+func newSizers(config *SplunkConfig) func(tenant string) *batchSizer {
+	var lock sync.Mutex
+	sizers := make(map[string]*batchSizer)
+	return func(tenant string) *batchSizer {
+		lock.Lock()
+		defer lock.Unlock()
+		sizer, ok := sizers[tenant]
+		if !ok {
+			sizer = newBatchSizer(config)
+			sizers[tenant] = sizer
+		}
+		return sizer
+	}
+}
+
+// consume parses, batches and sends the events of the queue, of a lane or of
+// a worker with ordered delivery, in a single goroutine
+func (s *Splunk) consume(writer eventwriter.Writer, queue <-chan *events.Envelope) {
+	defer s.wg.Done()
+
+	sizerOf := newSizers(s.config)
+	snd := s.newSender(writer, sizerOf)
+	s.batchEvents(queue, sizerOf, snd.send)
+}
+
+// parse is a parse worker of the lane: it parses, enriches and batches the
+// events of the lane and queues the batches for the send workers
+func (s *Splunk) parse(l *lane) {
+	defer s.wg.Done()
+
+	s.batchEvents(l.events, l.sizerOf, func(batch pendingBatch) {
+		l.batches <- batch
+	})
+}
+
+// send is a send worker of the lane: it sends the queued batches of the lane
+func (s *Splunk) send(writer eventwriter.Writer, l *lane) {
+	defer s.sendWg.Done()
+
+	snd := s.newSender(writer, l.sizerOf)
+	for batch := range l.batches {
+		snd.send(batch)
+	}
+}
+
+// batchEvents parses the events of the queue and batches them by destination.
+// Batches are sent when 1) the batch limits are reached 2) the flush window expires.
+func (s *Splunk) batchEvents(queue <-chan *events.Envelope, sizerOf func(tenant string) *batchSizer, send func(pendingBatch)) {
+	batches := make(map[destination][]map[string]interface{})
+	batchBytes := make(map[destination]int)
+	records := make(map[destination][]uint64)
+	timer := time.NewTimer(s.config.FlushInterval)
+
+	batchSize := func(dest destination) int {
+		if s.config.AdaptiveBatching {
+			return sizerOf(dest.tenant).limit()
+		}
+		return s.config.BatchSize
+	}
+	flush := func(dest destination) {
+		send(pendingBatch{dest: dest, events: batches[dest], records: records[dest]})
+		delete(batches, dest)
+		delete(batchBytes, dest)
+		delete(records, dest)
+	}
+	flushAll := func() {
+		for dest := range batches {
+			flush(dest)
+		}
+	}
+
+	batch := func(dest destination, finalEvent map[string]interface{}, record uint64) {
+		if s.config.MaxBatchBytes > 0 {
+			size := eventSize(s.payloadWriter(dest), finalEvent)
+			if len(batches[dest]) > 0 && batchBytes[dest]+size > s.config.MaxBatchBytes {
+				flush(dest)
+			}
+			batchBytes[dest] += size
+		}
+		if record != 0 {
+			s.acker.hold(record)
+			records[dest] = append(records[dest], record)
+		}
+		batches[dest] = append(batches[dest], finalEvent)
+		if len(batches[dest]) >= batchSize(dest) {
+			flush(dest)
+			if len(batches) == 0 {
+				timer.Reset(s.config.FlushInterval) // reset channel timer
+			}
+		}
+	}
+
+	// process parses the event and batches the HEC events built from it. The
+	// disk queue record of the event is held by these batches until they are
+	// sent.
+	process := func(event *events.Envelope) {
+		record := s.acker.take(event)
+		defer s.acker.done(record)
+
+		start := time.Now()
+		parsedEvent := s.parseEvent(event)
+		if parsedEvent == nil {
+			s.parseLatency.observe(time.Since(start))
+			return
+		}
+		// Aggregates account for every event, whatever the sampling of raw events
+		if !s.aggregateEvent(event.GetEventType(), parsedEvent) || sampledOut(parsedEvent) {
+			s.parseLatency.observe(time.Since(start))
+			return
+		}
+
+		if s.config.NativeMetrics && s.config.MetricWriter != nil && isPlatformMetric(event.GetEventType()) {
+			finalEvent := s.buildMetricEvent(parsedEvent)
+			s.parseLatency.observe(time.Since(start))
+			if finalEvent != nil {
+				batch(destination{tenant: metricsPartition}, finalEvent, record)
+			}
+			return
+		}
+
+		dest := destinationOf(s.tenantOf(parsedEvent), parsedEvent)
+		var finalEvents []map[string]interface{}
+		for _, fields := range s.limitMessage(parsedEvent) {
+			finalEvents = append(finalEvents, s.buildEvent(fields))
+		}
+		s.parseLatency.observe(time.Since(start))
+		for _, finalEvent := range finalEvents {
+			batch(dest, finalEvent, record)
+		}
+	}
+
+LOOP:
+	for {
+		select {
+		case event, ok := <-queue:
+			if !ok {
+				// events chan has closed and we have drained all events in it
+				break LOOP
+			}
+			process(event)
+
+		case <-timer.C:
+			flushAll()
+			timer.Reset(s.config.FlushInterval)
+		}
+	}
+	// Last batches
+	flushAll()
+}
+
+// latency is the average latency of a pipeline stage since it was last read
+type latency struct {
+	count uint64
+	total uint64 // nanoseconds
+}
+
+func (l *latency) observe(d time.Duration) {
+	atomic.AddUint64(&l.count, 1)
+	atomic.AddUint64(&l.total, uint64(d))
+}
+
+// read returns the average latency in milliseconds and resets it
+func (l *latency) read() float64 {
+	count := atomic.SwapUint64(&l.count, 0)
+	total := atomic.SwapUint64(&l.total, 0)
+	if count == 0 {
+		return 0
+	}
+	return float64(total) / float64(count) / float64(time.Millisecond)
+}
@@ -0,0 +1,86 @@
+package eventsink_test
+
+import (
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+)
+
+var _ = Describe("Staged pipeline", func() {
+	var (
+		memSink *testing.MemorySinkMock
+		lock    sync.Mutex
+		sent    int
+		release chan struct{}
+		writer  *testing.EventWriterMock
+		config  *eventsink.SplunkConfig
+		rconfig *eventrouter.Config
+	)
+
+	BeforeEach(func() {
+		msgs := make([]string, 25)
+		for i := range msgs {
+			msgs[i] = "hello"
+		}
+		memSink, rconfig = routeLogMessages(msgs...)
+
+		sent = 0
+		release = make(chan struct{})
+		writer = &testing.EventWriterMock{
+			PostBatchFn: func(events []map[string]interface{}) error {
+				<-release
+				lock.Lock()
+				sent += len(events)
+				lock.Unlock()
+				return nil
+			},
+		}
+		config = newSinkConfig()
+		config.FlushInterval = 10 * time.Second
+		config.QueueSize = 5
+		config.BatchSize = 5
+		config.OverflowPolicy = eventsink.OverflowBlock
+		config.ParseWorkers = 2
+		config.SendQueueSize = 4
+	})
+
+	sentEvents := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return sent
+	}
+
+	It("keeps parsing while the HEC workers are busy sending", func() {
+		sink := newTestSink(config, rconfig, writer)
+		Expect(sink.Open()).To(Succeed())
+
+		// The HEC worker blocks on the first batch, the other batches wait in
+		// the send queue
+		written := make(chan struct{})
+		go func() {
+			writeEvents(sink, memSink)
+			close(written)
+		}()
+		Eventually(written).Should(BeClosed())
+		Expect(sentEvents()).To(Equal(0))
+
+		close(release)
+		sink.Close()
+		Expect(sentEvents()).To(Equal(25))
+	})
+
+	It("drains both stages on close", func() {
+		close(release)
+		sink := newTestSink(config, rconfig, writer, writer)
+		Expect(sink.Open()).To(Succeed())
+		writeEvents(sink, memSink)
+		sink.Close()
+		Expect(sentEvents()).To(Equal(25))
+	})
+})
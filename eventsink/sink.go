@@ -4,6 +4,8 @@ import "github.com/cloudfoundry/sonde-go/events"
 
 type Sink interface {
 	Open() error
+	// Stop releases the blocked writes before Close, once the firehose is no longer read
+	Stop()
 	Close() error
 	Write(fields *events.Envelope) error
 }
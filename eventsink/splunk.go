@@ -1,8 +1,10 @@
 package eventsink
 
 import (
+	"errors"
 	"fmt"
 	"math"
+	"math/rand"
 	"strconv"
 	"strings"
 	"sync"
@@ -11,7 +13,10 @@ import (
 	"sync/atomic"
 
 	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/bosh"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/deadletter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/diskqueue"
 	fevents "github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/monitoring"
@@ -25,10 +30,13 @@ type SplunkConfig struct {
 	FlushInterval           time.Duration
 	QueueSize               int // consumer queue buffer size
 	BatchSize               int
-	Retries                 int // No of retries to post events to HEC before dropping events
+	Retries                 int           // No of retries to post events to HEC before dropping events
+	RetryInterval           time.Duration // wait between retries, 0 backs off exponentially from 5s
 	Hostname                string
 	SubscriptionID          string
 	ExtraFields             map[string]string
+	IndexedFields           []fevents.IndexedField
+	Transforms              fevents.Transforms
 	TraceLogging            bool
 	UUID                    string
 	Logger                  lager.Logger
@@ -36,6 +44,40 @@ type SplunkConfig struct {
 	LoggingIndex            string
 	RefreshSplunkConnection bool
 	KeepAliveTimer          time.Duration
+	Tenants                 []Tenant
+	NewTenantWriter         func(Tenant) eventwriter.Writer // creates writers for tenant HEC token and host
+	NewHealthCheck          func(Tenant) func() error       // creates HEC health checks for tenant HEC token and host, the zero Tenant being the default ones, nil probes with a trial batch
+	HttpMetricsWindow       time.Duration                   // HttpStartStop aggregation window, 0 disables aggregation
+	HttpMetricsKeepRaw      bool                            // keep sending raw HttpStartStop events along their aggregates
+	LogMetricRules          fevents.LogMetricRules
+	LogMetricsWindow        time.Duration
+	AppMetricsWindow        time.Duration      // ContainerMetric per app aggregation window, 0 disables aggregation
+	NativeMetrics           bool               // send platform metrics to the metrics index in HEC metric format
+	CounterRateTTL          time.Duration      // expiry of CounterEvent series for rate computation, 0 disables rates
+	MetricWriter            eventwriter.Writer // writer for the metrics index
+	Lookups                 *fevents.Lookups
+	BoshMetadata            *bosh.Metadata
+	ServiceBindings         *cache.ServiceBindings // service instances bound to apps, nil disables the enrichment
+	LookupReloadInterval    time.Duration
+	SanitizeMessages        bool // replace invalid UTF-8 and strip control characters of messages
+	MaxMessageSize          int  // bytes, 0 disables the oversized message policy
+	OversizedMessagePolicy  string
+	Queue                   *diskqueue.Queue  // persistent queue of events, nil buffers events in memory only
+	DeadLetters             *deadletter.Store // batches which ran out of retries, nil drops them
+	OverflowPolicy          string            // what Write does when the queue is full
+	OverflowMaxWait         time.Duration     // maximum wait of the block policy, 0 waits until there is room
+	Lanes                   []Lane            // priority lanes by event type, nil queues all events together
+	MaxBatchBytes           int               // maximum HEC payload of a batch, 0 only limits the number of events
+	AdaptiveBatching        bool              // adapt the batch size of each writer to the HEC latency and errors
+	MinBatchSize            int               // lower bound of the adaptive batch size
+	BatchLatencyTarget      time.Duration     // HEC latency above which the adaptive batch size shrinks
+	BreakerThreshold        int               // consecutive HEC outage failures opening the circuit breaker, 0 disables it
+	BreakerProbeInterval    time.Duration     // interval of the HEC health probes while the circuit breaker is open
+	BreakerAction           string            // what workers do with their batches while the circuit breaker is open
+	ParseWorkers            int               // parse workers decoupled from the HEC workers, 0 parses in the HEC workers
+	SendQueueSize           int               // batches queued between the parse and HEC workers of a lane
+	DrainTimeout            time.Duration     // time to send the queued events on Close before spilling them, 0 waits indefinitely
+	OrderedDelivery         bool              // send the events of an app instance with the same HEC worker, in order
 }
 
 type ParseConfig = fevents.Config
@@ -45,12 +87,37 @@ type Splunk struct {
 	config                *SplunkConfig
 	parseConfig           *ParseConfig
 	appCache              cache.Cache
-	events                chan *events.Envelope
+	lanes                 []*lane
+	lanesByEventType      map[events.Envelope_EventType]*lane
 	wg                    sync.WaitGroup
 	eventCount            uint64
 	sentCountChan         chan uint64
 	FirehoseDroppedEvents utils.Counter
 	SplunkDroppedEvents   utils.Counter
+	OversizedMessages     utils.Counter
+	DeadLetteredEvents    utils.Counter
+	DeadLetterFullEvents  utils.Counter
+
+	httpMetrics  *httpMetrics
+	logMetrics   *logMetrics
+	appMetrics   *appMetrics
+	counterRates *counterRates
+	done         chan struct{}
+	backgroundWg sync.WaitGroup
+	pumpDone     chan struct{}
+	pumpWg       sync.WaitGroup
+	breakers     map[string]*circuitBreaker // by tenant, nil when disabled
+	acker        *acker
+	sendWg       sync.WaitGroup
+	parseLatency latency
+	sendLatency  latency
+
+	stopping      chan struct{} // closed by Stop to release the blocked writes
+	stopOnce      sync.Once
+	stopped       time.Time     // start of the drain deadline
+	drainDeadline chan struct{} // closed once the drain deadline has passed
+	inFlight      uint64        // HEC events of the pending HEC requests
+	report        ShutdownReport
 
 	// cached IP
 	ip string
@@ -59,40 +126,283 @@ type Splunk struct {
 func NewSplunk(writers []eventwriter.Writer, config *SplunkConfig, parseConfig *ParseConfig, appCache cache.Cache) *Splunk {
 	hostname, ip, _ := utils.GetHostIPInfo(config.Hostname)
 	config.Hostname = hostname
+	lanes, lanesByEventType := newLanes(config)
 	splunk := &Splunk{
 		writers:               writers,
 		config:                config,
 		parseConfig:           parseConfig,
 		appCache:              appCache,
-		events:                make(chan *events.Envelope, config.QueueSize),
+		lanes:                 lanes,
+		lanesByEventType:      lanesByEventType,
 		ip:                    ip,
 		eventCount:            0,
 		sentCountChan:         make(chan uint64, 100),
+		stopping:              make(chan struct{}),
+		drainDeadline:         make(chan struct{}),
 		FirehoseDroppedEvents: monitoring.RegisterCounter("firehose.events.dropped.count", utils.UintType),
 		SplunkDroppedEvents:   monitoring.RegisterCounter("splunk.events.dropped.count", utils.UintType),
+		OversizedMessages:     monitoring.RegisterCounter("nozzle.messages.oversized.count", utils.UintType),
+		DeadLetteredEvents:    monitoring.RegisterCounter("splunk.events.deadlettered.count", utils.UintType),
+		DeadLetterFullEvents:  monitoring.RegisterCounter("splunk.events.deadletter.full.count", utils.UintType),
 	}
 	monitoring.RegisterFunc("nozzle.queue.percentage", func() interface{} {
-		return (float64(len(splunk.events)) / float64(splunk.config.QueueSize) * 100.0)
+		length, capacity := splunk.queueUsage()
+		return (float64(length) / float64(capacity) * 100.0)
 	})
+	if len(config.Lanes) > 0 {
+		for _, l := range lanes {
+			l := l
+			monitoring.RegisterFunc("nozzle.queue."+l.name+".percentage", func() interface{} {
+				return (float64(len(l.events)) / float64(cap(l.events)) * 100.0)
+			})
+		}
+	}
+	if config.Queue != nil {
+		splunk.acker = newAcker(config.Queue, config.Logger)
+		monitoring.RegisterFunc("nozzle.queue.disk.bytes", func() interface{} {
+			return config.Queue.Size()
+		})
+		monitoring.RegisterFunc("nozzle.queue.disk.oldest.age", func() interface{} {
+			return config.Queue.OldestAge().Seconds()
+		})
+	}
+	if config.ParseWorkers > 0 {
+		monitoring.RegisterFunc("nozzle.pipeline.parse.latency", func() interface{} {
+			return splunk.parseLatency.read()
+		})
+		monitoring.RegisterFunc("nozzle.pipeline.send.latency", func() interface{} {
+			return splunk.sendLatency.read()
+		})
+		monitoring.RegisterFunc("nozzle.pipeline.parse.queue.depth", func() interface{} {
+			length, _ := splunk.queueUsage()
+			return length
+		})
+		monitoring.RegisterFunc("nozzle.pipeline.send.queue.depth", func() interface{} {
+			depth := 0
+			for _, l := range splunk.lanes {
+				depth += len(l.batches)
+			}
+			return depth
+		})
+	}
+	if config.BreakerThreshold > 0 {
+		splunk.breakers = newBreakers(config)
+		for tenant, b := range splunk.breakers {
+			id := "splunk.circuitbreaker.state"
+			switch tenant {
+			case "":
+			case metricsPartition:
+				id = "splunk.circuitbreaker.metrics.state"
+			default:
+				id = "splunk.circuitbreaker.tenant." + tenant + ".state"
+			}
+			b := b
+			monitoring.RegisterFunc(id, func() interface{} {
+				return breakerStates[b.current()]
+			})
+		}
+	}
+	if config.HttpMetricsWindow > 0 && config.MetricWriter != nil {
+		splunk.httpMetrics = newHttpMetrics()
+	}
+	if len(config.LogMetricRules) > 0 && config.LogMetricsWindow > 0 && config.MetricWriter != nil {
+		splunk.logMetrics = newLogMetrics(config.LogMetricRules)
+	}
+	if config.AppMetricsWindow > 0 && config.MetricWriter != nil {
+		splunk.appMetrics = newAppMetrics()
+	}
+	if config.CounterRateTTL > 0 {
+		splunk.counterRates = newCounterRates(config.CounterRateTTL)
+	}
 
 	return splunk
 }
 
 func (s *Splunk) Open() error {
-	for _, client := range s.writers[:len(s.writers)-1] {
-		s.wg.Add(1)
-		go s.consume(client)
+	if s.config.Queue != nil && len(s.config.Lanes) > 0 {
+		// Events leave the disk queue in order, a full lane would hold up the others
+		return errors.New("priority lanes can't be used with the disk queue")
+	}
+	workers, err := laneWorkers(s.lanes, len(s.writers)-1)
+	if err != nil {
+		return err
+	}
+	var parseWorkers []int
+	if s.config.ParseWorkers > 0 {
+		if s.config.OrderedDelivery {
+			return errors.New("ordered delivery requires parsing in the HEC workers")
+		}
+		if parseWorkers, err = laneWorkers(s.lanes, s.config.ParseWorkers); err != nil {
+			return err
+		}
+	}
+
+	clients := s.writers[:len(s.writers)-1]
+	for i, l := range s.lanes {
+		if s.config.OrderedDelivery {
+			l.order(workers[i])
+			s.wg.Add(1)
+			go s.dispatch(l)
+		}
+		for j, client := range clients[:workers[i]] {
+			switch {
+			case s.config.OrderedDelivery:
+				s.wg.Add(1)
+				go s.consume(client, l.workerQueues[j])
+			case parseWorkers == nil:
+				s.wg.Add(1)
+				go s.consume(client, l.events)
+			default:
+				s.sendWg.Add(1)
+				go s.send(client, l)
+			}
+		}
+		clients = clients[workers[i]:]
+
+		if parseWorkers != nil {
+			for j := 0; j < parseWorkers[i]; j++ {
+				s.wg.Add(1)
+				go s.parse(l)
+			}
+		}
+	}
+	if s.config.OrderedDelivery {
+		s.registerOrderedMetrics()
+	}
+	s.done = make(chan struct{})
+	if s.config.Queue != nil {
+		s.pumpDone = make(chan struct{})
+		s.pumpWg.Add(1)
+		go s.pump()
+	}
+	if s.httpMetrics != nil {
+		s.backgroundWg.Add(1)
+		go s.aggregate(s.httpMetrics, s.config.HttpMetricsWindow)
+	}
+	if s.logMetrics != nil {
+		s.backgroundWg.Add(1)
+		go s.aggregate(s.logMetrics, s.config.LogMetricsWindow)
+	}
+	if s.appMetrics != nil {
+		s.backgroundWg.Add(1)
+		go s.aggregate(s.appMetrics, s.config.AppMetricsWindow)
+	}
+	if s.config.Lookups != nil && s.config.LookupReloadInterval > 0 {
+		s.backgroundWg.Add(1)
+		go s.reloadLookups()
 	}
 	return nil
 }
 
+// Stop releases the writes blocked on a full queue, dropping their events, and
+// starts the drain deadline. It lets the firehose consumer exit before Close
+// while HEC is unreachable.
+func (s *Splunk) Stop() {
+	s.stopOnce.Do(func() {
+		s.stopped = time.Now()
+		close(s.stopping)
+	})
+}
+
 func (s *Splunk) Close() error {
+	s.Stop()
+	if s.config.Queue != nil {
+		// Events left on disk are sent after the next start
+		close(s.pumpDone)
+		s.pumpWg.Wait()
+	}
+	// Drain the queue whatever the state of Splunk
+	for _, b := range s.breakers {
+		b.shutdown()
+	}
 	// Notify the consume loop to drain events and exit
-	close(s.events)
-	s.wg.Wait()
+	for _, l := range s.lanes {
+		close(l.events)
+	}
+	drained := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		// Then the send workers send the last batches
+		for _, l := range s.lanes {
+			close(l.batches)
+		}
+		s.sendWg.Wait()
+		close(drained)
+	}()
+	s.drain(drained)
+	// Flush the last windows once all events have been consumed
+	close(s.done)
+	s.backgroundWg.Wait()
+	s.logReport()
+	if s.config.Queue != nil {
+		return s.config.Queue.Close()
+	}
 	return nil
 }
 
+// pump moves events from the disk queue to the consumers. Events are removed
+// from the disk queue once their batches have been sent.
+func (s *Splunk) pump() {
+	defer s.pumpWg.Done()
+
+	for {
+		data, ok := s.config.Queue.Peek(s.pumpDone)
+		if !ok {
+			return
+		}
+
+		msg := &events.Envelope{}
+		if err := msg.Unmarshal(data); err != nil {
+			s.config.Logger.Error("Dropping undecodable event of disk queue", err)
+			s.acker.done(s.acker.read(nil))
+			continue
+		}
+
+		// Registered before the hand-over, the consumer takes it right away
+		s.acker.read(msg)
+		select {
+		case s.laneOf(msg.GetEventType()).events <- msg:
+		case <-s.pumpDone:
+			return
+		}
+	}
+}
+
+// reloadLookups reloads the lookup files changed on disk every interval
+func (s *Splunk) reloadLookups() {
+	defer s.backgroundWg.Done()
+
+	ticker := time.NewTicker(s.config.LookupReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.config.Lookups.Reload(); err != nil {
+				s.config.Logger.Error("Unable to reload lookups", err)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// aggregate sends the aggregates to the metrics index every window
+func (s *Splunk) aggregate(agg aggregator, window time.Duration) {
+	defer s.backgroundWg.Done()
+
+	ticker := time.NewTicker(window)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.indexEvents(destination{tenant: metricsPartition}, s.config.MetricWriter, agg.flush(now, s.config.Hostname))
+		case <-s.done:
+			s.indexEvents(destination{tenant: metricsPartition}, s.config.MetricWriter, agg.flush(time.Now(), s.config.Hostname))
+			return
+		}
+	}
+}
+
 // parseEvent parses the event received from the doppler
 func (s *Splunk) parseEvent(msg *events.Envelope) map[string]interface{} {
 	eventType := msg.GetEventType()
@@ -121,10 +431,27 @@ func (s *Splunk) parseEvent(msg *events.Envelope) map[string]interface{} {
 	}
 
 	event.AnnotateWithEnvelopeData(msg, s.parseConfig)
+	if eventType == events.Envelope_ContainerMetric {
+		event.AnnotateWithContainerUsage(s.parseConfig)
+	}
+	if eventType == events.Envelope_CounterEvent && s.counterRates != nil {
+		s.counterRates.annotate(msg, event.Fields)
+	}
 	event.AnnotateWithCFMetaData()
 
 	if _, hasAppId := event.Fields["cf_app_id"]; hasAppId {
 		event.AnnotateWithAppData(s.appCache, s.parseConfig)
+		if s.config.ServiceBindings != nil && event.Fields["cf_ignored_app"] != true {
+			event.AnnotateWithServiceBindings(s.config.ServiceBindings)
+		}
+	}
+
+	if s.config.BoshMetadata != nil {
+		s.config.BoshMetadata.Annotate(event.Fields)
+	}
+
+	if s.config.Lookups != nil {
+		s.config.Lookups.Annotate(event.Fields)
 	}
 
 	if ignored, ok := event.Fields["cf_ignored_app"]; ok {
@@ -144,71 +471,130 @@ func (s *Splunk) parseEvent(msg *events.Envelope) map[string]interface{} {
 }
 
 func (s *Splunk) Write(fields *events.Envelope) error {
-	select {
-	case s.events <- fields:
-	default:
+	atomic.AddUint64(&s.report.Received, 1)
+	if s.config.Queue != nil {
+		data, err := fields.Marshal()
+		if err != nil || !s.push(data) {
+			s.FirehoseDroppedEvents.Add(1)
+			atomic.AddUint64(&s.report.QueueDropped, 1)
+		}
+		return nil
+	}
+
+	if !s.enqueue(fields) {
 		s.FirehoseDroppedEvents.Add(1)
+		atomic.AddUint64(&s.report.QueueDropped, 1)
 	}
 	return nil
 }
 
-func (s *Splunk) consume(writer eventwriter.Writer) {
-	defer s.wg.Done()
-
-	var batch []map[string]interface{}
-	timer := time.NewTimer(s.config.FlushInterval)
-
-	// Flush takes place when 1) batch limit is reached. 2) flush window expires
-LOOP:
-	for {
-		select {
-		case event, ok := <-s.events:
-			if !ok {
-				// events chan has closed and we have drained all events in it
-				break LOOP
-			}
-
-			parsedEvent := s.parseEvent(event)
-			if parsedEvent != nil {
-				finalEvent := s.buildEvent(parsedEvent)
-				batch = append(batch, finalEvent)
-				if len(batch) >= s.config.BatchSize {
-					batch = s.indexEvents(writer, batch)
-					timer.Reset(s.config.FlushInterval) // reset channel timer
-				}
-			}
-
-		case <-timer.C:
-			batch = s.indexEvents(writer, batch)
-			timer.Reset(s.config.FlushInterval)
-		}
-
+// aggregateEvent feeds the aggregators with the event. It returns false when
+// the raw event is replaced by its aggregates.
+func (s *Splunk) aggregateEvent(eventType events.Envelope_EventType, fields map[string]interface{}) bool {
+	switch {
+	case eventType == events.Envelope_HttpStartStop && s.httpMetrics != nil:
+		s.httpMetrics.add(fields)
+		return s.config.HttpMetricsKeepRaw
+	case eventType == events.Envelope_LogMessage && s.logMetrics != nil:
+		s.logMetrics.add(fields)
+	case eventType == events.Envelope_ContainerMetric && s.appMetrics != nil:
+		s.appMetrics.add(fields)
 	}
-	// Last batch
-	s.indexEvents(writer, batch)
+	return true
 }
 
-// indexEvents indexes events to Splunk
+// sampledOut tells whether the event is dropped by the app's sampling rate metadata
+func sampledOut(fields map[string]interface{}) bool {
+	rate, ok := fields["info_splunk_sampling_rate"].(float64)
+	return ok && rand.Float64() >= rate
+}
+
+// indexEvents indexes events of the destination to Splunk
 // return nil when successful which clears all outstanding events
 // return what the batch has if there is an error for next retry cycle
-func (s *Splunk) indexEvents(writer eventwriter.Writer, batch []map[string]interface{}) []map[string]interface{} {
+// batches running out of retries go to the dead-letter store when configured
+func (s *Splunk) indexEvents(dest destination, writer eventwriter.Writer, batch []map[string]interface{}) []map[string]interface{} {
 	if len(batch) == 0 {
 		return batch
 	}
 	var err error
+	diverted := false
+	breaker := s.breakerOf(dest.tenant)
 	for i := 0; i < s.config.Retries; i++ {
-		err, sentCount := writer.Write(batch)
+		if s.drainExpired() {
+			err = errDrainDeadline
+			break
+		}
+		if breaker != nil && !breaker.allow(s.config.BreakerAction != BreakerActionDeadLetter) {
+			if err == nil {
+				err = errBreakerOpen
+			}
+			diverted = true
+			break
+		}
+
+		var sentCount uint64
+		atomic.AddUint64(&s.inFlight, uint64(len(batch)))
+		err, sentCount = writer.Write(batch)
+		atomic.AddUint64(&s.inFlight, ^uint64(len(batch)-1))
+		if breaker != nil {
+			breaker.record(err)
+		}
 		if err == nil {
+			atomic.AddUint64(&s.report.Sent, uint64(len(batch)))
 			if s.config.StatusMonitorInterval > time.Second*0 {
 				s.sentCountChan <- sentCount
 			}
 			return nil
 		}
-		s.config.Logger.Error("Unable to talk to Splunk", err, lager.Data{"Retry attempt": i + 1})
-		time.Sleep(getRetryInterval(i))
+		data := dest.logData()
+		data["Retry attempt"] = i + 1
+		s.config.Logger.Error("Unable to talk to Splunk", err, data)
+		if breaker != nil && breaker.current() == BreakerOpen {
+			// The breaker paces the retries while open
+			continue
+		}
+		select {
+		case <-time.After(s.retryInterval(i)):
+		case <-s.drainDeadline:
+		}
+	}
+	spilled := s.drainExpired()
+
+	if s.config.DeadLetters != nil {
+		record := deadletter.NewRecord(batch, err)
+		if diverted {
+			record.ErrorType = deadletter.ErrorTypeBreakerOpen
+		}
+		record.Metrics = dest.tenant == metricsPartition
+		if !record.Metrics {
+			record.Tenant = dest.tenant
+		}
+		record.Index = dest.index
+		dlErr := s.config.DeadLetters.Add(record)
+		if dlErr == nil {
+			data := dest.logData()
+			data["events"] = len(batch)
+			if spilled {
+				atomic.AddUint64(&s.report.Spilled, uint64(len(batch)))
+				s.config.Logger.Error("Spilling events to dead-letter store after the drain deadline", err, data)
+				return nil
+			}
+			s.DeadLetteredEvents.Add(len(batch))
+			atomic.AddUint64(&s.report.DeadLettered, uint64(len(batch)))
+			s.config.Logger.Error("Finish retrying and writing events to dead-letter store", err, data)
+			return nil
+		}
+		if dlErr == deadletter.ErrFull {
+			s.DeadLetterFullEvents.Add(len(batch))
+		}
+		s.config.Logger.Error("Unable to write events to dead-letter store", dlErr)
 	}
 	s.SplunkDroppedEvents.Add(len(batch))
-	s.config.Logger.Error("Finish retrying and dropping events", err, lager.Data{"events": len(batch)})
+	atomic.AddUint64(&s.report.Dropped, uint64(len(batch)))
+	data := dest.logData()
+	data["events"] = len(batch)
+	s.config.Logger.Error("Finish retrying and dropping events", err, data)
 	return nil
 }
 
@@ -241,6 +627,13 @@ func (s *Splunk) buildEvent(fields map[string]interface{}) map[string]interface{
 		event["sourcetype"] = fmt.Sprintf("cf:%s", strings.ToLower(eventType))
 	}
 
+	// Per app overrides from app env or metadata
+	for field, key := range eventwriter.AppOverrideFields {
+		if v, ok := fields[field]; ok && v != nil && v != "" {
+			event[key] = v
+		}
+	}
+
 	extraFields := make(map[string]interface{})
 
 	if s.config.TraceLogging {
@@ -251,6 +644,27 @@ func (s *Splunk) buildEvent(fields map[string]interface{}) map[string]interface{
 	for k, v := range s.config.ExtraFields {
 		extraFields[k] = v
 	}
+	for _, indexedField := range s.config.IndexedFields {
+		v, ok := fields[indexedField.Field]
+		if !ok || v == nil {
+			continue
+		}
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			// HEC indexed fields only accept flat values
+			continue
+		case string:
+			extraFields[indexedField.Name] = v
+		default:
+			extraFields[indexedField.Name] = fmt.Sprint(v)
+		}
+		if indexedField.Move {
+			delete(fields, indexedField.Field)
+		}
+	}
+	eventType, _ := fields["event_type"].(string)
+	s.config.Transforms.Apply(eventType, fields)
+
 	event["fields"] = extraFields
 	event["event"] = fields
 	return event
@@ -298,7 +712,8 @@ func (s *Splunk) LogStatus() {
 	for {
 		select {
 		case <-timer.C:
-			percent := float64(len(s.events)) / float64(s.config.QueueSize) * 100.0
+			length, capacity := s.queueUsage()
+			percent := float64(length) / float64(capacity) * 100.0
 			status := "low"
 			switch {
 			case percent > 99.9:
@@ -307,7 +722,7 @@ func (s *Splunk) LogStatus() {
 				status = "high"
 			}
 			if status != "low" {
-				s.config.Logger.Info("Memory_Queue_Pressure", lager.Data{"events_in_consumer_queue": len(s.events), "percentage": int(percent), "status": status})
+				s.config.Logger.Info("Memory_Queue_Pressure", lager.Data{"events_in_consumer_queue": length, "percentage": int(percent), "status": status})
 			}
 			sent = 0
 			timer.Reset(s.config.StatusMonitorInterval)
@@ -321,6 +736,13 @@ func (s *Splunk) LogStatus() {
 	}
 }
 
+func (s *Splunk) retryInterval(attempt int) time.Duration {
+	if s.config.RetryInterval > 0 {
+		return s.config.RetryInterval
+	}
+	return getRetryInterval(attempt)
+}
+
 func getRetryInterval(attempt int) time.Duration {
 	// algorithm taken from https://en.wikipedia.org/wiki/Exponential_backoff
 	timeInSec := 5 + (0.5 * (math.Exp2(float64(attempt)) - 1.0))
@@ -1,9 +1,22 @@
 package eventsink_test
 
 import (
+	"bytes"
+	"sync"
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
+	mocks "github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+	"github.com/cloudfoundry/sonde-go/events"
+
 	"testing"
 )
 
@@ -11,3 +24,103 @@ func TestSplunk(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Drain Suite")
 }
+
+// logBuffer collects the logs of a test
+type logBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+// newBufferedLogger returns a logger writing its logs to logs
+func newBufferedLogger(logs *logBuffer) lager.Logger {
+	logger := lager.NewLogger("test")
+	logger.RegisterSink(lager.NewWriterSink(logs, lager.INFO))
+	return logger
+}
+
+// newRouter returns a router of the selected events to a memory sink, which
+// collects the envelopes to write to the sink under test
+func newRouter(selectedEvents string) (eventrouter.Router, *mocks.MemorySinkMock, *eventrouter.Config) {
+	memSink := mocks.NewMemorySinkMock()
+	rconfig := &eventrouter.Config{SelectedEvents: selectedEvents}
+	router, err := eventrouter.New(cache.NewNoCache(), memSink, rconfig)
+	Ω(err).ShouldNot(HaveOccurred())
+	return router, memSink, rconfig
+}
+
+// routeLogMessages routes a LogMessage envelope per message to a memory sink
+func routeLogMessages(msgs ...string) (*mocks.MemorySinkMock, *eventrouter.Config) {
+	router, memSink, rconfig := newRouter("LogMessage")
+	for _, msg := range msgs {
+		router.Route(logMessage(msg))
+	}
+	return memSink, rconfig
+}
+
+// logMessage returns a LogMessage envelope with the fields required to
+// marshal it, e.g. for the disk queue
+func logMessage(msg string) *events.Envelope {
+	origin := "rep"
+	eventType := events.Envelope_LogMessage
+	messageType := events.LogMessage_OUT
+	timestamp := time.Now().UnixNano()
+	return &events.Envelope{
+		Origin:    &origin,
+		EventType: &eventType,
+		LogMessage: &events.LogMessage{
+			Message:     []byte(msg),
+			MessageType: &messageType,
+			Timestamp:   &timestamp,
+		},
+	}
+}
+
+// newSinkConfig returns the sink config of the specs, which flushes and
+// retries without waiting
+func newSinkConfig() *eventsink.SplunkConfig {
+	return &eventsink.SplunkConfig{
+		FlushInterval: time.Millisecond,
+		QueueSize:     100,
+		BatchSize:     100,
+		Retries:       1,
+		RetryInterval: time.Millisecond,
+		Hostname:      "localhost",
+		Logger:        lager.NewLogger("test"),
+	}
+}
+
+// newTestSink creates a sink sending to the HEC writers, with a writer for
+// its own logs and counters that the specs can read once the sink is closed
+func newTestSink(config *eventsink.SplunkConfig, rconfig *eventrouter.Config, writers ...eventwriter.Writer) *eventsink.Splunk {
+	return newCachedTestSink(config, rconfig, mocks.NewMemoryCacheMock(), writers...)
+}
+
+// newCachedTestSink creates a test sink annotating events from appCache
+func newCachedTestSink(config *eventsink.SplunkConfig, rconfig *eventrouter.Config, appCache cache.Cache, writers ...eventwriter.Writer) *eventsink.Splunk {
+	writers = append(writers, &mocks.EventWriterMock{})
+	sink := eventsink.NewSplunk(writers, config, rconfig, appCache)
+	sink.FirehoseDroppedEvents = new(utils.IntCounter)
+	sink.SplunkDroppedEvents = new(utils.IntCounter)
+	sink.DeadLetteredEvents = new(utils.IntCounter)
+	sink.DeadLetterFullEvents = new(utils.IntCounter)
+	return sink
+}
+
+// writeEvents writes the envelopes collected by memSink to the sink
+func writeEvents(sink *eventsink.Splunk, memSink *mocks.MemorySinkMock) {
+	for _, event := range memSink.Events {
+		sink.Write(event)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"code.cloudfoundry.org/lager"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
+	fevents "github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
 	"github.com/cloudfoundry/sonde-go/events"
 
@@ -196,6 +197,64 @@ var _ = Describe("Splunk", func() {
 		Expect(index).To(Equal(jobIndex))
 	})
 
+	Context("app with CF metadata", func() {
+		var appCache *testing.MemoryCacheMock
+
+		BeforeEach(func() {
+			appId := "8463ec45-543c-4492-9ec6-f52707f7dd2b"
+			messageType := events.LogMessage_OUT
+			envelope.LogMessage = &events.LogMessage{
+				Message:     []byte("App debug log message"),
+				MessageType: &messageType,
+				Timestamp:   &timestampNano,
+				AppId:       &appId,
+			}
+			eventType = events.Envelope_LogMessage
+			eventRouter.Route(envelope)
+
+			appCache = testing.NewMemoryCacheMock()
+			sink = eventsink.NewSplunk([]eventwriter.Writer{mockClient, mockClient2}, config, rconfig, appCache)
+		})
+
+		It("uses sourcetype from metadata", func() {
+			appCache.SetMetadata(map[string]string{cache.MetadataSourcetype: "team:logs"})
+			sink.Open()
+			sink.Write(memSink.Events[0])
+
+			Eventually(func() []map[string]interface{} {
+				return mockClient.CapturedEvents()
+			}).Should(HaveLen(1))
+			Expect(mockClient.CapturedEvents()[0]["sourcetype"]).To(Equal("team:logs"))
+		})
+
+		It("uses sourcetype, source and host from app env", func() {
+			appCache.SetAppEnv(map[string]interface{}{
+				"SPLUNK_SOURCETYPE": "env:logs",
+				"SPLUNK_SOURCE":     "billing",
+				"SPLUNK_HOST":       "billing.example.com",
+			})
+			sink.Open()
+			sink.Write(memSink.Events[0])
+
+			Eventually(func() []map[string]interface{} {
+				return mockClient.CapturedEvents()
+			}).Should(HaveLen(1))
+			event = mockClient.CapturedEvents()[0]
+			Expect(event["sourcetype"]).To(Equal("env:logs"))
+			Expect(event["source"]).To(Equal("billing"))
+			Expect(event["host"]).To(Equal("billing.example.com"))
+		})
+
+		It("drops events sampled out by metadata", func() {
+			appCache.SetMetadata(map[string]string{cache.MetadataSamplingRate: "0"})
+			sink.Open()
+			sink.Write(memSink.Events[0])
+			sink.Close()
+
+			Expect(mockClient.CapturedEvents()).To(BeEmpty())
+		})
+	})
+
 	Context("envelope HttpStartStop", func() {
 		var envelopeHttpStartStop *events.HttpStartStop
 		var startTimestamp, stopTimestamp int64
@@ -349,6 +408,67 @@ var _ = Describe("Splunk", func() {
 			Expect(event["time"]).To(Equal(eventTimeSeconds))
 		})
 
+		It("applies transforms", func() {
+			config.Transforms = fevents.Transforms{
+				{Action: fevents.TransformRename, Field: "cf_app_id", To: "app.guid"},
+				{Action: fevents.TransformDrop, Field: "job_index", EventTypes: []string{"LogMessage"}},
+			}
+			sink.Write(memSink.Events[0])
+
+			Eventually(func() []map[string]interface{} {
+				return mockClient.CapturedEvents()
+			}).Should(HaveLen(2))
+
+			event = mockClient.CapturedEvents()[1]
+			Expect(event["sourcetype"]).To(Equal("cf:logmessage"))
+			eventContents := event["event"].(map[string]interface{})
+			Expect(eventContents["app.guid"]).To(Equal(appId))
+			Expect(eventContents).NotTo(HaveKey("cf_app_id"))
+			Expect(eventContents).NotTo(HaveKey("job_index"))
+		})
+
+		It("merges lookup fields", func() {
+			file, _ := os.CreateTemp("", "lookup*.json")
+			defer os.Remove(file.Name())
+			file.WriteString(`{"dea_logging_agent":{"team":"platform"}}`)
+			file.Close()
+
+			config.Lookups, err = fevents.NewLookups(`[{"file":"` + file.Name() + `","field":"origin"}]`)
+			Ω(err).ShouldNot(HaveOccurred())
+			sink.Write(memSink.Events[0])
+
+			Eventually(func() []map[string]interface{} {
+				return mockClient.CapturedEvents()
+			}).Should(HaveLen(2))
+
+			eventContents := mockClient.CapturedEvents()[1]["event"].(map[string]interface{})
+			Expect(eventContents["team"]).To(Equal("platform"))
+		})
+
+		It("promotes indexed fields", func() {
+			config.IndexedFields = []fevents.IndexedField{
+				{Field: "origin", Name: "origin"},
+				{Field: "source_instance", Name: "instance", Move: true},
+				{Field: "missing", Name: "missing"},
+			}
+			sink.Write(memSink.Events[0])
+
+			Eventually(func() []map[string]interface{} {
+				return mockClient.CapturedEvents()
+			}).Should(HaveLen(2))
+
+			event = mockClient.CapturedEvents()[1]
+			fields := event["fields"].(map[string]interface{})
+			Expect(fields["origin"]).To(Equal("dea_logging_agent"))
+			Expect(fields["instance"]).To(Equal("0"))
+			Expect(fields).NotTo(HaveKey("missing"))
+			Expect(fields["env"]).To(Equal("dev"))
+
+			eventContents := event["event"].(map[string]interface{})
+			Expect(eventContents["origin"]).To(Equal("dea_logging_agent"))
+			Expect(eventContents).NotTo(HaveKey("source_instance"))
+		})
+
 		It("adds fields to payload.event", func() {
 			eventContents := event["event"].(map[string]interface{})
 
@@ -12,6 +12,9 @@ func (l *Std) Open() error {
 	return nil
 }
 
+func (l *Std) Stop() {
+}
+
 func (l *Std) Close() error {
 	return nil
 }
@@ -0,0 +1,115 @@
+package eventsink
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+)
+
+// Tenant maps apps, spaces or orgs to their own HEC token and optionally
+// their own HEC host. Org, Space and App match either names or GUIDs.
+type Tenant struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	Host  string `json:"host"`
+	Org   string `json:"org"`
+	Space string `json:"space"`
+	App   string `json:"app"`
+}
+
+// ParseTenants parses tenants from a JSON list or a file containing the list
+func ParseTenants(tenantsString string) ([]Tenant, error) {
+	tenantsString = strings.TrimSpace(tenantsString)
+	if tenantsString == "" {
+		return nil, nil
+	}
+
+	var tenants []Tenant
+	if err := utils.UnmarshalJSONOrFile(tenantsString, &tenants); err != nil {
+		return nil, fmt.Errorf("failed to parse tenants: %s", err)
+	}
+
+	names := make(map[string]bool, len(tenants))
+	for _, t := range tenants {
+		if t.Name == "" || t.Token == "" {
+			return nil, fmt.Errorf("tenant %+v must have a name and a token", t)
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("duplicate tenant name %s", t.Name)
+		}
+		names[t.Name] = true
+	}
+	return tenants, nil
+}
+
+// tenantOf returns the name of the tenant the event belongs to, or "" for the
+// default destination. An explicit tenant from app metadata wins, otherwise
+// the most specific matching tenant (app, then space, then org) is used.
+func (s *Splunk) tenantOf(fields map[string]interface{}) string {
+	if len(s.config.Tenants) == 0 {
+		return ""
+	}
+
+	if name, ok := fields["info_splunk_tenant"].(string); ok {
+		for _, t := range s.config.Tenants {
+			if t.Name == name {
+				return name
+			}
+		}
+	}
+
+	best, bestScore := "", 0
+	for _, t := range s.config.Tenants {
+		score := 0
+		for _, selector := range []struct {
+			value  string
+			fields []string
+			weight int
+		}{
+			{t.App, []string{"cf_app_id", "cf_app_name"}, 4},
+			{t.Space, []string{"cf_space_id", "cf_space_name"}, 2},
+			{t.Org, []string{"cf_org_id", "cf_org_name"}, 1},
+		} {
+			if selector.value == "" {
+				continue
+			}
+			if !matchesAny(fields, selector.fields, selector.value) {
+				score = 0
+				break
+			}
+			score += selector.weight
+		}
+		if score > bestScore {
+			best, bestScore = t.Name, score
+		}
+	}
+	return best
+}
+
+func matchesAny(fields map[string]interface{}, keys []string, value string) bool {
+	for _, key := range keys {
+		if v, ok := fields[key]; ok && fmt.Sprintf("%v", v) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// tenantWriter returns the writer of the tenant, creating it on first use.
+// writers is owned by a single consume goroutine.
+func (s *Splunk) tenantWriter(writers map[string]eventwriter.Writer, tenant string) eventwriter.Writer {
+	if w, ok := writers[tenant]; ok {
+		return w
+	}
+
+	for _, t := range s.config.Tenants {
+		if t.Name == tenant && s.config.NewTenantWriter != nil {
+			w := s.config.NewTenantWriter(t)
+			writers[tenant] = w
+			return w
+		}
+	}
+	return writers[""]
+}
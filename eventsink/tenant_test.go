@@ -0,0 +1,123 @@
+package eventsink_test
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+)
+
+var _ = Describe("Tenants", func() {
+
+	Context("ParseTenants", func() {
+		It("returns no tenants for an empty string", func() {
+			tenants, err := eventsink.ParseTenants("")
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(tenants).To(BeEmpty())
+		})
+
+		It("parses a JSON list", func() {
+			tenants, err := eventsink.ParseTenants(`[{"name":"finance","token":"abc","org":"finance-org"}]`)
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(tenants).To(Equal([]eventsink.Tenant{{Name: "finance", Token: "abc", Org: "finance-org"}}))
+		})
+
+		It("reads tenants from a file", func() {
+			file, _ := os.CreateTemp("", "tenants")
+			defer os.Remove(file.Name())
+			file.WriteString(`[{"name":"finance","token":"abc","host":"https://finance:8088"}]`)
+			file.Close()
+
+			tenants, err := eventsink.ParseTenants(file.Name())
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(tenants[0].Host).To(Equal("https://finance:8088"))
+		})
+
+		It("rejects tenants without a token", func() {
+			_, err := eventsink.ParseTenants(`[{"name":"finance"}]`)
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("rejects duplicate tenant names", func() {
+			_, err := eventsink.ParseTenants(`[{"name":"a","token":"1"},{"name":"a","token":"2"}]`)
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("routing", func() {
+		var (
+			memSink       *testing.MemorySinkMock
+			appCache      *testing.MemoryCacheMock
+			defaultWriter *testing.EventWriterMock
+			tenantWriters map[string]*testing.EventWriterMock
+			sink          *eventsink.Splunk
+			config        *eventsink.SplunkConfig
+			rconfig       *eventrouter.Config
+		)
+
+		BeforeEach(func() {
+			var router eventrouter.Router
+			router, memSink, rconfig = newRouter("LogMessage")
+			rconfig.AddOrgName = true
+			rconfig.AddSpaceName = true
+			appCache = testing.NewMemoryCacheMock()
+
+			appId := "f964a41c-76ac-42c1-b2ba-663da3ec22d5"
+			event := logMessage("hello")
+			event.LogMessage.AppId = &appId
+			router.Route(event)
+
+			defaultWriter = &testing.EventWriterMock{}
+			tenantWriters = map[string]*testing.EventWriterMock{}
+			config = newSinkConfig()
+			config.BatchSize = 1
+			config.Tenants = []eventsink.Tenant{
+				{Name: "org", Token: "1", Org: "testing-org"},
+				{Name: "space", Token: "2", Org: "testing-org", Space: "testing-space"},
+				{Name: "other", Token: "3", Space: "other-space"},
+			}
+			config.NewTenantWriter = func(t eventsink.Tenant) eventwriter.Writer {
+				w := &testing.EventWriterMock{}
+				tenantWriters[t.Name] = w
+				return w
+			}
+			sink = newCachedTestSink(config, rconfig, appCache, defaultWriter)
+		})
+
+		It("sends events to the most specific tenant", func() {
+			sink.Open()
+			sink.Write(memSink.Events[0])
+			sink.Close()
+
+			Expect(defaultWriter.CapturedEvents()).To(BeEmpty())
+			Expect(tenantWriters).To(HaveKey("space"))
+			Expect(tenantWriters["space"].CapturedEvents()).To(HaveLen(1))
+		})
+
+		It("sends events to the tenant from app metadata", func() {
+			appCache.SetMetadata(map[string]string{cache.MetadataTenant: "other"})
+			sink.Open()
+			sink.Write(memSink.Events[0])
+			sink.Close()
+
+			Expect(tenantWriters).To(HaveKey("other"))
+			Expect(tenantWriters["other"].CapturedEvents()).To(HaveLen(1))
+		})
+
+		It("sends unmatched events to the default destination", func() {
+			config.Tenants = []eventsink.Tenant{{Name: "other", Token: "3", Org: "other-org"}}
+			sink.Open()
+			sink.Write(memSink.Events[0])
+			sink.Close()
+
+			Expect(defaultWriter.CapturedEvents()).To(HaveLen(1))
+			Expect(tenantWriters).To(BeEmpty())
+		})
+	})
+})
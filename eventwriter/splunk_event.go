@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"crypto/tls"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -17,6 +16,14 @@ import (
 
 var keepAliveTimer = time.Now()
 
+// AppOverrideFields maps the per app override fields annotated on the event
+// to the HEC metadata keys they override
+var AppOverrideFields = map[string]string{
+	"info_splunk_sourcetype": "sourcetype",
+	"info_splunk_source":     "source",
+	"info_splunk_host":       "host",
+}
+
 type SplunkConfig struct {
 	Host                    string
 	Token                   string
@@ -85,19 +92,37 @@ func (s *SplunkEvent) Write(events []map[string]interface{}) (error, uint64) {
 }
 
 func (s *SplunkEvent) parseEvent(event *map[string]interface{}) error {
-	if _, ok := (*event)["index"]; !ok {
-		if (*event)["event"].(map[string]interface{})["info_splunk_index"] != nil {
-			(*event)["index"] = (*event)["event"].(map[string]interface{})["info_splunk_index"]
+	for key, v := range s.AddedFields(*event) {
+		(*event)[key] = v
+	}
+	return nil
+}
+
+// AddedFields returns the index, per app overrides and indexed fields the
+// writer sets on the event
+func (s *SplunkEvent) AddedFields(event map[string]interface{}) map[string]interface{} {
+	added := make(map[string]interface{})
+	data, _ := event["event"].(map[string]interface{})
+
+	if _, ok := event["index"]; !ok {
+		if data["info_splunk_index"] != nil {
+			added["index"] = data["info_splunk_index"]
 		} else if s.config.Index != "" {
-			(*event)["index"] = s.config.Index
+			added["index"] = s.config.Index
+		}
+	}
+
+	for field, key := range AppOverrideFields {
+		if v, ok := data[field]; ok && v != nil && v != "" {
+			added[key] = v
 		}
 	}
 
 	if len(s.config.Fields) > 0 {
-		(*event)["fields"] = s.config.Fields
+		added["fields"] = s.config.Fields
 	}
 
-	return nil
+	return added
 }
 
 func (s *SplunkEvent) send(postBody *[]byte) error {
@@ -121,7 +146,7 @@ func (s *SplunkEvent) send(postBody *[]byte) error {
 
 	if resp.StatusCode > 299 {
 		responseBody, _ := io.ReadAll(resp.Body)
-		return errors.New(fmt.Sprintf("Non-ok response code [%d] from splunk: %s", resp.StatusCode, responseBody))
+		return &HECError{StatusCode: resp.StatusCode, Body: string(responseBody)}
 	} else {
 		if s.config.RefreshSplunkConnection && time.Now().After(keepAliveTimer) {
 			if s.config.KeepAliveTimer > 0 {
@@ -139,6 +164,28 @@ func (s *SplunkEvent) send(postBody *[]byte) error {
 	return nil
 }
 
+// CheckHealth returns nil when the HEC health endpoint reports HEC as healthy
+func (s *SplunkEvent) CheckHealth() error {
+	endpoint := fmt.Sprintf("%s/services/collector/health", s.config.Host)
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Splunk %s", s.config.Token))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	responseBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode > 299 {
+		return &HECError{StatusCode: resp.StatusCode, Body: string(responseBody)}
+	}
+	return nil
+}
+
 // To dump the event on stdout instead of Splunk, in case of 'debug' mode
 func (s *SplunkEvent) dump(eventString string) error {
 	fmt.Println(string(eventString))
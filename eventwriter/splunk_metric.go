@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"crypto/tls"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -37,7 +36,9 @@ func (s *splunkMetric) Write(events []map[string]interface{}) (error, uint64) {
 	bodyBuffer := new(bytes.Buffer)
 	count := uint64(len(events))
 	for _, event := range events {
-		event["index"] = s.config.Index
+		for key, v := range s.AddedFields(event) {
+			event[key] = v
+		}
 		eventJson, err := json.Marshal(event)
 		if err == nil {
 			bodyBuffer.Write(eventJson)
@@ -55,6 +56,11 @@ func (s *splunkMetric) Write(events []map[string]interface{}) (error, uint64) {
 	return s.send(&bodyBytes), count
 }
 
+// AddedFields returns the metrics index the writer sets on the event
+func (s *splunkMetric) AddedFields(event map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"index": s.config.Index}
+}
+
 func (s *splunkMetric) send(postBody *[]byte) error {
 	endpoint := fmt.Sprintf("%s/services/collector", s.config.Host)
 	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(*postBody))
@@ -76,7 +82,7 @@ func (s *splunkMetric) send(postBody *[]byte) error {
 
 	if resp.StatusCode > 299 {
 		responseBody, _ := ioutil.ReadAll(resp.Body)
-		return errors.New(fmt.Sprintf("Non-ok response code [%d] from splunk: %s", resp.StatusCode, responseBody))
+		return &HECError{StatusCode: resp.StatusCode, Body: string(responseBody)}
 	} else {
 		//Draining the response buffer, so that the same connection can be reused the next time
 		_, err := io.Copy(ioutil.Discard, resp.Body)
@@ -1,6 +1,7 @@
 package eventwriter_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -197,6 +198,27 @@ var _ = Describe("Splunk", func() {
 			Expect(string(capturedBody)).To(Equal(expectedPayload))
 		})
 
+		It("honours app sourcetype, source and host overrides", func() {
+			client := NewSplunkEvent(config)
+			event1 := map[string]interface{}{"sourcetype": "cf:logmessage", "event": map[string]interface{}{
+				"info_splunk_sourcetype": "team:logs",
+				"info_splunk_source":     "billing",
+				"info_splunk_host":       "billing.example.com",
+			}}
+
+			events := []map[string]interface{}{event1}
+			err, _ := client.Write(events)
+
+			Expect(err).To(BeNil())
+			Expect(capturedRequest).NotTo(BeNil())
+
+			var captured map[string]interface{}
+			Expect(json.Unmarshal(capturedBody, &captured)).To(Succeed())
+			Expect(captured["sourcetype"]).To(Equal("team:logs"))
+			Expect(captured["source"]).To(Equal("billing"))
+			Expect(captured["host"]).To(Equal("billing.example.com"))
+		})
+
 		It("adds fields to splunk payload", func() {
 			fields := map[string]string{
 				"foo":   "bar",
@@ -1,5 +1,24 @@
 package eventwriter
 
+import "fmt"
+
 type Writer interface {
 	Write([]map[string]interface{}) (error, uint64)
 }
+
+// FieldsAdder is implemented by writers setting HEC metadata on the events
+// they send, e.g. their index, so that batches can account for it
+type FieldsAdder interface {
+	// AddedFields returns the fields the writer sets on the event
+	AddedFields(event map[string]interface{}) map[string]interface{}
+}
+
+// HECError is the error of a request rejected by HEC
+type HECError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HECError) Error() string {
+	return fmt.Sprintf("Non-ok response code [%d] from splunk: %s", e.StatusCode, e.Body)
+}
@@ -37,6 +37,14 @@ func main() {
 	}
 
 	splunkNozzle := splunknozzle.NewSplunkFirehoseNozzle(config, logger)
+	if config.Command == splunknozzle.CommandReplay {
+		if err := splunkNozzle.Replay(); err != nil {
+			logger.Error("Failed to replay dead-letter batches", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	err := splunkNozzle.Run(shutdownChan)
 	if err != nil {
 		logger.Error("Failed to run splunk-firehose-nozzle", err)
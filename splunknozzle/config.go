@@ -6,7 +6,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/diskqueue"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
 
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
@@ -22,6 +24,7 @@ type Config struct {
 	SplunkHost         string `json:"splunk-host"`
 	SplunkIndex        string `json:"splunk-index"`
 	SplunkLoggingIndex string `json:"splunk-logging-index"`
+	HecTenants         string `json:"-"`
 
 	JobHost string `json:"job-host"`
 
@@ -37,19 +40,80 @@ type Config struct {
 	OrgSpaceCacheTTL   time.Duration `json:"org-space-cache-ttl"`
 	AppLimits          int           `json:"app-limits"`
 	AddTags            bool          `json:"add-tags"`
+	UseCFMetadata      bool          `json:"use-cf-metadata"`
 
-	BoltDBPath   string `json:"boltdb-path"`
-	WantedEvents string `json:"wanted-events"`
-	ExtraFields  string `json:"extra-fields"`
+	AddServiceBindings     bool          `json:"add-service-bindings"`
+	ServiceBindingCacheTTL time.Duration `json:"service-binding-cache-ttl"`
+	ServiceBindingErrorTTL time.Duration `json:"service-binding-error-ttl"`
+
+	BoltDBPath    string `json:"boltdb-path"`
+	WantedEvents  string `json:"wanted-events"`
+	ExtraFields   string `json:"extra-fields"`
+	IndexedFields string `json:"indexed-fields"`
+	Transforms    string `json:"transforms"`
+	OutputSchema  string `json:"output-schema"`
+
+	Lookups              string        `json:"lookups"`
+	LookupReloadInterval time.Duration `json:"lookup-reload-interval"`
+
+	BoshMetadata        string        `json:"bosh-metadata"`
+	BoshDirectorURL     string        `json:"bosh-director-url"`
+	BoshUsername        string        `json:"-"`
+	BoshPassword        string        `json:"-"`
+	SkipSSLBosh         bool          `json:"skip-ssl-bosh"`
+	BoshRefreshInterval time.Duration `json:"bosh-refresh-interval"`
+
+	HttpMetricsWindow  time.Duration `json:"http-metrics-window"`
+	HttpMetricsKeepRaw bool          `json:"http-metrics-keep-raw"`
+	LogMetrics         string        `json:"log-metrics"`
+	LogMetricsWindow   time.Duration `json:"log-metrics-window"`
+	NativeMetrics      bool          `json:"native-metrics"`
+	AppMetricsWindow   time.Duration `json:"app-metrics-window"`
+	CounterRates       bool          `json:"counter-rates"`
+	CounterRateTTL     time.Duration `json:"counter-rate-ttl"`
+
+	SanitizeMessages       bool   `json:"sanitize-messages"`
+	MaxMessageSize         int    `json:"max-message-size"`
+	OversizedMessagePolicy string `json:"oversized-message-policy"`
+
+	CPUEntitlementPerGB float64 `json:"cpu-entitlement-per-gb"`
+	QuotaThreshold      float64 `json:"quota-threshold"`
 
 	FlushInterval           time.Duration `json:"flush-interval"`
 	QueueSize               int           `json:"queue-size"`
 	BatchSize               int           `json:"batch-size"`
 	Retries                 int           `json:"retries"`
 	HecWorkers              int           `json:"hec-workers"`
+	BatchMaxBytes           int           `json:"hec-batch-max-bytes"`
+	AdaptiveBatching        bool          `json:"hec-adaptive-batching"`
+	MinBatchSize            int           `json:"hec-min-batch-size"`
+	BatchLatencyTarget      time.Duration `json:"hec-batch-latency-target"`
+	BreakerThreshold        int           `json:"circuit-breaker-threshold"`
+	BreakerProbeInterval    time.Duration `json:"circuit-breaker-probe-interval"`
+	BreakerAction           string        `json:"circuit-breaker-action"`
+	ParseWorkers            int           `json:"parse-workers"`
+	SendQueueSize           int           `json:"send-queue-size"`
+	DrainTimeout            time.Duration `json:"drain-timeout"`
+	OrderedDelivery         bool          `json:"ordered-delivery"`
 	RefreshSplunkConnection bool          `json:"refresh-splunk-connection"`
 	KeepAliveTimer          time.Duration `json:"keep-alive-timer"`
+	OverflowPolicy          string        `json:"overflow-policy"`
+	OverflowMaxWait         time.Duration `json:"overflow-max-wait"`
+	PriorityLanes           string        `json:"priority-lanes"`
+
+	DiskQueueDir          string        `json:"disk-queue-dir"`
+	DiskQueueMaxSize      int64         `json:"disk-queue-max-size"`
+	DiskQueueSegmentSize  int64         `json:"disk-queue-segment-size"`
+	DiskQueueSyncPolicy   string        `json:"disk-queue-sync-policy"`
+	DiskQueueSyncInterval time.Duration `json:"disk-queue-sync-interval"`
 
+	DeadLetterDir     string `json:"dead-letter-dir"`
+	DeadLetterMaxSize int64  `json:"dead-letter-max-size"`
+	ReplayFrom        string `json:"replay-from"`
+	ReplayTo          string `json:"replay-to"`
+	ReplayErrorTypes  string `json:"replay-error-types"`
+
+	Command string `json:"command"`
 	Version string `json:"version"`
 	Branch  string `json:"branch"`
 	Commit  string `json:"commit"`
@@ -63,6 +127,12 @@ type Config struct {
 	MemoryBallastSize         int           `json:"memory-ballast-size"`
 }
 
+// Commands of the nozzle
+const (
+	CommandRun    = "run"
+	CommandReplay = "replay"
+)
+
 func NewConfigFromCmdFlags(version, branch, commit, buildos string) *Config {
 	c := &Config{}
 
@@ -91,6 +161,8 @@ func NewConfigFromCmdFlags(version, branch, commit, buildos string) *Config {
 		OverrideDefaultFromEnvar("SPLUNK_INDEX").Required().StringVar(&c.SplunkIndex)
 	kingpin.Flag("splunk-logging-index", "Splunk logging index").
 		OverrideDefaultFromEnvar("SPLUNK_LOGGING_INDEX").StringVar(&c.SplunkLoggingIndex)
+	kingpin.Flag("hec-tenants", "JSON list (or path of a JSON file) mapping apps, spaces or orgs to their own HEC token and host").
+		OverrideDefaultFromEnvar("HEC_TENANTS").Default("").StringVar(&c.HecTenants)
 
 	kingpin.Flag("job-host", "Job host to tag nozzle's own log events").
 		OverrideDefaultFromEnvar("JOB_HOST").Default("").StringVar(&c.JobHost)
@@ -114,10 +186,18 @@ func NewConfigFromCmdFlags(version, branch, commit, buildos string) *Config {
 		OverrideDefaultFromEnvar("APP_CACHE_INVALIDATE_TTL").Default("0s").DurationVar(&c.AppCacheTTL)
 	kingpin.Flag("org-space-cache-invalidate-ttl", "How frequently the org and space cache invalidates").
 		OverrideDefaultFromEnvar("ORG_SPACE_CACHE_INVALIDATE_TTL").Default("72h").DurationVar(&c.OrgSpaceCacheTTL)
+	kingpin.Flag("add-service-bindings", "Enrich app events with the service instances bound to the app").
+		OverrideDefaultFromEnvar("ADD_SERVICE_BINDINGS").Default("false").BoolVar(&c.AddServiceBindings)
+	kingpin.Flag("service-binding-cache-invalidate-ttl", "How frequently the service bindings cache invalidates").
+		OverrideDefaultFromEnvar("SERVICE_BINDING_CACHE_INVALIDATE_TTL").Default("10m").DurationVar(&c.ServiceBindingCacheTTL)
+	kingpin.Flag("service-binding-error-cache-ttl", "How long failed service binding requests are cached before they are retried").
+		OverrideDefaultFromEnvar("SERVICE_BINDING_ERROR_CACHE_TTL").Default("1m").DurationVar(&c.ServiceBindingErrorTTL)
 	kingpin.Flag("app-limits", "Restrict to APP_LIMITS most updated apps per request when populating the app metadata cache").
 		OverrideDefaultFromEnvar("APP_LIMITS").Default("0").IntVar(&c.AppLimits)
 	kingpin.Flag("add-tags", "Add additional tags from envelope. (Default: false)").
 		OverrideDefaultFromEnvar("ADD_TAGS").Default("false").BoolVar(&c.AddTags)
+	kingpin.Flag("use-cf-metadata", "Honour f2s.splunk.com/* labels and annotations on apps, spaces and orgs").
+		OverrideDefaultFromEnvar("USE_CF_METADATA").Default("false").BoolVar(&c.UseCFMetadata)
 
 	kingpin.Flag("boltdb-path", "Bolt Database path ").
 		Default("cache.db").OverrideDefaultFromEnvar("BOLTDB_PATH").StringVar(&c.BoltDBPath)
@@ -125,6 +205,54 @@ func NewConfigFromCmdFlags(version, branch, commit, buildos string) *Config {
 		OverrideDefaultFromEnvar("EVENTS").Default("ValueMetric,CounterEvent,ContainerMetric").StringVar(&c.WantedEvents)
 	kingpin.Flag("extra-fields", "Extra fields you want to annotate your events with, example: '--extra-fields=env:dev,something:other ").
 		OverrideDefaultFromEnvar("EXTRA_FIELDS").Default("").StringVar(&c.ExtraFields)
+	kingpin.Flag("indexed-fields", "Event fields to promote into HEC indexed fields, example: '--indexed-fields=cf_app_name,cf_org_name:org,status_code::move'").
+		OverrideDefaultFromEnvar("INDEXED_FIELDS").Default("").StringVar(&c.IndexedFields)
+	kingpin.Flag("transforms", "JSON list (or path of a JSON file) of rules to rename, drop, copy, set, lowercase or nest event fields").
+		OverrideDefaultFromEnvar("TRANSFORMS").Default("").StringVar(&c.Transforms)
+	kingpin.Flag("output-schema", "Field naming of events: default, otel (OpenTelemetry semantic conventions) or ecs (Elastic Common Schema)").
+		OverrideDefaultFromEnvar("OUTPUT_SCHEMA").Default("default").EnumVar(&c.OutputSchema, events.SchemaDefault, events.SchemaOTel, events.SchemaECS)
+	kingpin.Flag("lookups", "JSON list (or path of a JSON file) of CSV or JSON lookup files mapping an event field to extra fields").
+		OverrideDefaultFromEnvar("LOOKUPS").Default("").StringVar(&c.Lookups)
+	kingpin.Flag("lookup-reload-interval", "Interval to check lookup files for changes, 0s disables reloading").
+		OverrideDefaultFromEnvar("LOOKUP_RELOAD_INTERVAL").Default("30s").DurationVar(&c.LookupReloadInterval)
+	kingpin.Flag("bosh-metadata", "JSON (or path of a JSON file) mapping BOSH deployments to products and instances to instance groups and availability zones").
+		OverrideDefaultFromEnvar("BOSH_METADATA").Default("").StringVar(&c.BoshMetadata)
+	kingpin.Flag("bosh-director-url", "URL of a BOSH director API to fetch deployments and instances from").
+		OverrideDefaultFromEnvar("BOSH_DIRECTOR_URL").Default("").StringVar(&c.BoshDirectorURL)
+	kingpin.Flag("bosh-username", "Username of the BOSH director API").
+		OverrideDefaultFromEnvar("BOSH_USERNAME").Default("").StringVar(&c.BoshUsername)
+	kingpin.Flag("bosh-password", "Password of the BOSH director API").
+		OverrideDefaultFromEnvar("BOSH_PASSWORD").Default("").StringVar(&c.BoshPassword)
+	kingpin.Flag("skip-ssl-validation-bosh", "Skip cert validation of the BOSH director API (for dev environments)").
+		OverrideDefaultFromEnvar("SKIP_SSL_VALIDATION_BOSH").Default("false").BoolVar(&c.SkipSSLBosh)
+	kingpin.Flag("bosh-refresh-interval", "Interval to refresh deployments and instances from the BOSH director API").
+		OverrideDefaultFromEnvar("BOSH_REFRESH_INTERVAL").Default("10m").DurationVar(&c.BoshRefreshInterval)
+	kingpin.Flag("http-metrics-window", "Window to aggregate HttpStartStop events into request, error and latency metrics sent to the metric index, 0s disables aggregation").
+		OverrideDefaultFromEnvar("HTTP_METRICS_WINDOW").Default("0s").DurationVar(&c.HttpMetricsWindow)
+	kingpin.Flag("http-metrics-keep-raw", "Keep sending raw HttpStartStop events when they are aggregated").
+		OverrideDefaultFromEnvar("HTTP_METRICS_KEEP_RAW").Default("false").BoolVar(&c.HttpMetricsKeepRaw)
+	kingpin.Flag("log-metrics", "JSON list (or path of a JSON file) of rules extracting metrics from LogMessage content, sent to the metric index").
+		OverrideDefaultFromEnvar("LOG_METRICS").Default("").StringVar(&c.LogMetrics)
+	kingpin.Flag("log-metrics-window", "Window to aggregate metrics extracted from LogMessage content").
+		OverrideDefaultFromEnvar("LOG_METRICS_WINDOW").Default("1m").DurationVar(&c.LogMetricsWindow)
+	kingpin.Flag("native-metrics", "Send ValueMetric, CounterEvent and ContainerMetric events to the metric index in HEC metric format").
+		OverrideDefaultFromEnvar("NATIVE_METRICS").Default("false").BoolVar(&c.NativeMetrics)
+	kingpin.Flag("app-metrics-window", "Window to aggregate ContainerMetric events per app across instances, sent to the metric index, 0s disables aggregation").
+		OverrideDefaultFromEnvar("APP_METRICS_WINDOW").Default("0s").DurationVar(&c.AppMetricsWindow)
+	kingpin.Flag("counter-rates", "Add the per-second rate computed from the totals of CounterEvent series").
+		OverrideDefaultFromEnvar("COUNTER_RATES").Default("false").BoolVar(&c.CounterRates)
+	kingpin.Flag("counter-rate-ttl", "Time after which CounterEvent series not seen anymore are forgotten").
+		OverrideDefaultFromEnvar("COUNTER_RATE_TTL").Default("5m").DurationVar(&c.CounterRateTTL)
+	kingpin.Flag("sanitize-messages", "Replace invalid UTF-8 and strip control characters of messages").
+		OverrideDefaultFromEnvar("SANITIZE_MESSAGES").Default("false").BoolVar(&c.SanitizeMessages)
+	kingpin.Flag("max-message-size", "Maximum size of messages in bytes, 0 for unlimited").
+		OverrideDefaultFromEnvar("MAX_MESSAGE_SIZE").Default("0").IntVar(&c.MaxMessageSize)
+	kingpin.Flag("oversized-message-policy", "What to do with messages over the maximum size: truncate, split or drop").
+		OverrideDefaultFromEnvar("OVERSIZED_MESSAGE_POLICY").Default("truncate").EnumVar(&c.OversizedMessagePolicy, events.OversizedTruncate, events.OversizedSplit, events.OversizedDrop)
+	kingpin.Flag("cpu-entitlement-per-gb", "Share of a CPU core, in percent, apps are entitled to per GB of memory quota, used to compute cpu_entitlement_percentage of ContainerMetric events, 0 disables it").
+		OverrideDefaultFromEnvar("CPU_ENTITLEMENT_PER_GB").Default("0").Float64Var(&c.CPUEntitlementPerGB)
+	kingpin.Flag("quota-threshold", "Flag ContainerMetric memory and disk usage within this percentage of quota, 0 disables the flags").
+		OverrideDefaultFromEnvar("QUOTA_THRESHOLD").Default("0").Float64Var(&c.QuotaThreshold)
 
 	kingpin.Flag("flush-interval", "Every interval flushes to Splunk Http Event Collector server").
 		OverrideDefaultFromEnvar("FLUSH_INTERVAL").Default("5s").DurationVar(&c.FlushInterval)
@@ -136,10 +264,60 @@ func NewConfigFromCmdFlags(version, branch, commit, buildos string) *Config {
 		OverrideDefaultFromEnvar("HEC_RETRIES").Default("5").IntVar(&c.Retries)
 	kingpin.Flag("hec-workers", "How many workers (concurrency) when post data to HEC").
 		OverrideDefaultFromEnvar("HEC_WORKERS").Default("8").IntVar(&c.HecWorkers)
+	kingpin.Flag("hec-batch-max-bytes", "Maximum payload in bytes of the batches pushing to HEC, 0 only limits the number of events").
+		OverrideDefaultFromEnvar("HEC_BATCH_MAX_BYTES").Default("0").IntVar(&c.BatchMaxBytes)
+	kingpin.Flag("hec-adaptive-batching", "Adapt the batch size of each HEC worker to the HEC latency and errors").
+		OverrideDefaultFromEnvar("HEC_ADAPTIVE_BATCHING").Default("false").BoolVar(&c.AdaptiveBatching)
+	kingpin.Flag("hec-min-batch-size", "Minimum batch size with adaptive batching").
+		OverrideDefaultFromEnvar("HEC_MIN_BATCH_SIZE").Default("10").IntVar(&c.MinBatchSize)
+	kingpin.Flag("hec-batch-latency-target", "HEC latency above which adaptive batching shrinks the batch size").
+		OverrideDefaultFromEnvar("HEC_BATCH_LATENCY_TARGET").Default("1s").DurationVar(&c.BatchLatencyTarget)
+	kingpin.Flag("circuit-breaker-threshold", "Consecutive HEC outage failures opening the circuit breaker, 0 disables the circuit breaker").
+		OverrideDefaultFromEnvar("CIRCUIT_BREAKER_THRESHOLD").Default("0").IntVar(&c.BreakerThreshold)
+	kingpin.Flag("circuit-breaker-probe-interval", "Interval of the HEC health probes while the circuit breaker is open").
+		OverrideDefaultFromEnvar("CIRCUIT_BREAKER_PROBE_INTERVAL").Default("10s").DurationVar(&c.BreakerProbeInterval)
+	kingpin.Flag("circuit-breaker-action", "What HEC workers do with their batches while the circuit breaker is open: pause or dead-letter").
+		OverrideDefaultFromEnvar("CIRCUIT_BREAKER_ACTION").Default(eventsink.BreakerActionPause).EnumVar(&c.BreakerAction, eventsink.BreakerActionPause, eventsink.BreakerActionDeadLetter)
+	kingpin.Flag("parse-workers", "How many workers parse and batch events apart from the HEC workers, 0 parses in the HEC workers").
+		OverrideDefaultFromEnvar("PARSE_WORKERS").Default("0").IntVar(&c.ParseWorkers)
+	kingpin.Flag("send-queue-size", "Batches queued between the parse workers and the HEC workers of a lane").
+		OverrideDefaultFromEnvar("SEND_QUEUE_SIZE").Default("100").IntVar(&c.SendQueueSize)
+	kingpin.Flag("drain-timeout", "Time to send the queued events on shutdown before spilling them to the dead-letter store or dropping them, 0 waits indefinitely").
+		OverrideDefaultFromEnvar("DRAIN_TIMEOUT").Default("0s").DurationVar(&c.DrainTimeout)
+	kingpin.Flag("ordered-delivery", "Send the events of each app instance with the same HEC worker so that they arrive in order").
+		OverrideDefaultFromEnvar("ORDERED_DELIVERY").Default("false").BoolVar(&c.OrderedDelivery)
 	kingpin.Flag("refresh-splunk-connection", "Periodically refresh connection to Splunk").
 		OverrideDefaultFromEnvar("REFRESH_SPLUNK_CONNECTION").Default("false").BoolVar(&c.RefreshSplunkConnection)
 	kingpin.Flag("keep-alive-timer", "Interval used to close and refresh connection to Splunk").
 		OverrideDefaultFromEnvar("KEEP_ALIVE_TIMER").Default("30s").DurationVar(&c.KeepAliveTimer)
+	kingpin.Flag("overflow-policy", "What to do with new events when the queue is full: drop-newest, drop-oldest or block").
+		OverrideDefaultFromEnvar("OVERFLOW_POLICY").Default(eventsink.OverflowDropNewest).EnumVar(&c.OverflowPolicy, eventsink.OverflowDropNewest, eventsink.OverflowDropOldest, eventsink.OverflowBlock)
+	kingpin.Flag("overflow-max-wait", "Maximum time the block overflow policy waits for room before dropping the event, 0 waits indefinitely").
+		OverrideDefaultFromEnvar("OVERFLOW_MAX_WAIT").Default("0s").DurationVar(&c.OverflowMaxWait)
+	kingpin.Flag("priority-lanes", "JSON list, or path of a JSON file, of priority lanes with their event types, worker weight, queue size and overflow policy").
+		OverrideDefaultFromEnvar("PRIORITY_LANES").Default("").StringVar(&c.PriorityLanes)
+
+	kingpin.Flag("disk-queue-dir", "Directory of the persistent queue of events, empty keeps events in memory only").
+		OverrideDefaultFromEnvar("DISK_QUEUE_DIR").Default("").StringVar(&c.DiskQueueDir)
+	kingpin.Flag("disk-queue-max-size", "Maximum size in MB of the persistent queue, new events are dropped once reached").
+		OverrideDefaultFromEnvar("DISK_QUEUE_MAX_SIZE").Default("1024").Int64Var(&c.DiskQueueMaxSize)
+	kingpin.Flag("disk-queue-segment-size", "Size in MB of the persistent queue segment files").
+		OverrideDefaultFromEnvar("DISK_QUEUE_SEGMENT_SIZE").Default("64").Int64Var(&c.DiskQueueSegmentSize)
+	kingpin.Flag("disk-queue-sync-policy", "When the persistent queue is synced to disk: always, interval or never").
+		OverrideDefaultFromEnvar("DISK_QUEUE_SYNC_POLICY").Default(diskqueue.SyncInterval).EnumVar(&c.DiskQueueSyncPolicy, diskqueue.SyncAlways, diskqueue.SyncInterval, diskqueue.SyncNever)
+	kingpin.Flag("disk-queue-sync-interval", "Interval to sync the persistent queue to disk with the interval sync policy").
+		OverrideDefaultFromEnvar("DISK_QUEUE_SYNC_INTERVAL").Default("1s").DurationVar(&c.DiskQueueSyncInterval)
+
+	kingpin.Flag("dead-letter-dir", "Directory of the batches which could not be sent to Splunk, empty drops them").
+		OverrideDefaultFromEnvar("DEAD_LETTER_DIR").Default("").StringVar(&c.DeadLetterDir)
+	kingpin.Flag("dead-letter-max-size", "Maximum size in MB of the dead-letter store, batches are dropped once reached, 0 means unlimited").
+		OverrideDefaultFromEnvar("DEAD_LETTER_MAX_SIZE").Default("1024").Int64Var(&c.DeadLetterMaxSize)
+	kingpin.Flag("replay-from", "Replay the dead-letter batches failed at or after this RFC3339 time").
+		OverrideDefaultFromEnvar("REPLAY_FROM").Default("").StringVar(&c.ReplayFrom)
+	kingpin.Flag("replay-to", "Replay the dead-letter batches failed before this RFC3339 time").
+		OverrideDefaultFromEnvar("REPLAY_TO").Default("").StringVar(&c.ReplayTo)
+	kingpin.Flag("replay-error-types", "Comma separated list of error types of the dead-letter batches to replay, e.g. network,http_503,http_5xx").
+		OverrideDefaultFromEnvar("REPLAY_ERROR_TYPES").Default("").StringVar(&c.ReplayErrorTypes)
 
 	kingpin.Flag("enable-event-tracing", "Enable event trace logging: Adds splunk trace logging fields to events. uuid, firehose-subscription-id, nozzle event counter").
 		OverrideDefaultFromEnvar("ENABLE_EVENT_TRACING").Default("false").BoolVar(&c.TraceLogging)
@@ -154,7 +332,10 @@ func NewConfigFromCmdFlags(version, branch, commit, buildos string) *Config {
 	kingpin.Flag("memory-ballast-size", "Size of ballast in MB").
 		OverrideDefaultFromEnvar("MEMORY_BALLAST_SIZE").Default("0").IntVar(&c.MemoryBallastSize)
 
-	kingpin.Parse()
+	kingpin.Command(CommandRun, "Run the nozzle").Default()
+	kingpin.Command(CommandReplay, "Re-send the dead-letter batches to Splunk")
+
+	c.Command = kingpin.Parse()
 	c.ApiEndpoint = strings.TrimSpace(c.ApiEndpoint)
 	c.SplunkHost = strings.TrimRight(strings.TrimSpace(c.SplunkHost), "/")
 	return c
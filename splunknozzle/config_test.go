@@ -55,10 +55,63 @@ var _ = Describe("Config", func() {
 			os.Setenv("BOLTDB_PATH", "foo.db")
 			os.Setenv("EVENTS", "LogMessage")
 			os.Setenv("EXTRA_FIELDS", "foo:bar")
+			os.Setenv("INDEXED_FIELDS", "cf_app_name")
+			os.Setenv("TRANSFORMS", `[{"action":"drop","field":"job_index"}]`)
 			os.Setenv("ADD_TAGS", "true")
+			os.Setenv("USE_CF_METADATA", "true")
+			os.Setenv("ADD_SERVICE_BINDINGS", "true")
+			os.Setenv("SERVICE_BINDING_CACHE_INVALIDATE_TTL", "1h")
+			os.Setenv("SERVICE_BINDING_ERROR_CACHE_TTL", "5m")
+			os.Setenv("HEC_TENANTS", `[{"name":"finance","token":"abc"}]`)
+			os.Setenv("OUTPUT_SCHEMA", "otel")
+			os.Setenv("HTTP_METRICS_WINDOW", "1m")
+			os.Setenv("HTTP_METRICS_KEEP_RAW", "true")
+			os.Setenv("LOG_METRICS", `[{"name":"orders","type":"counter","regex":"processed"}]`)
+			os.Setenv("LOG_METRICS_WINDOW", "30s")
+			os.Setenv("NATIVE_METRICS", "true")
+			os.Setenv("APP_METRICS_WINDOW", "2m")
+			os.Setenv("CPU_ENTITLEMENT_PER_GB", "25")
+			os.Setenv("QUOTA_THRESHOLD", "5")
+			os.Setenv("COUNTER_RATES", "true")
+			os.Setenv("COUNTER_RATE_TTL", "10m")
+			os.Setenv("SANITIZE_MESSAGES", "true")
+			os.Setenv("LOOKUPS", "/etc/nozzle/lookups.json")
+			os.Setenv("LOOKUP_RELOAD_INTERVAL", "1m")
+			os.Setenv("BOSH_METADATA", "/etc/nozzle/bosh.json")
+			os.Setenv("BOSH_DIRECTOR_URL", "https://bosh.example.com:25555")
+			os.Setenv("BOSH_USERNAME", "admin")
+			os.Setenv("BOSH_PASSWORD", "secret")
+			os.Setenv("SKIP_SSL_VALIDATION_BOSH", "true")
+			os.Setenv("BOSH_REFRESH_INTERVAL", "1h")
+			os.Setenv("MAX_MESSAGE_SIZE", "65536")
+			os.Setenv("OVERSIZED_MESSAGE_POLICY", "split")
 
 			os.Setenv("FLUSH_INTERVAL", "43s")
 			os.Setenv("CONSUMER_QUEUE_SIZE", "15000")
+			os.Setenv("DISK_QUEUE_DIR", "/var/vcap/data/nozzle/queue")
+			os.Setenv("DISK_QUEUE_MAX_SIZE", "4096")
+			os.Setenv("DISK_QUEUE_SEGMENT_SIZE", "128")
+			os.Setenv("DISK_QUEUE_SYNC_POLICY", "always")
+			os.Setenv("DISK_QUEUE_SYNC_INTERVAL", "5s")
+			os.Setenv("HEC_BATCH_MAX_BYTES", "1048576")
+			os.Setenv("HEC_ADAPTIVE_BATCHING", "true")
+			os.Setenv("HEC_MIN_BATCH_SIZE", "50")
+			os.Setenv("HEC_BATCH_LATENCY_TARGET", "2s")
+			os.Setenv("CIRCUIT_BREAKER_THRESHOLD", "5")
+			os.Setenv("CIRCUIT_BREAKER_PROBE_INTERVAL", "30s")
+			os.Setenv("CIRCUIT_BREAKER_ACTION", "dead-letter")
+			os.Setenv("PARSE_WORKERS", "4")
+			os.Setenv("SEND_QUEUE_SIZE", "50")
+			os.Setenv("DRAIN_TIMEOUT", "8s")
+			os.Setenv("ORDERED_DELIVERY", "true")
+			os.Setenv("OVERFLOW_POLICY", "block")
+			os.Setenv("OVERFLOW_MAX_WAIT", "30s")
+			os.Setenv("PRIORITY_LANES", `[{"name":"logs","events":["LogMessage"]}]`)
+			os.Setenv("DEAD_LETTER_DIR", "/var/vcap/data/nozzle/deadletter")
+			os.Setenv("DEAD_LETTER_MAX_SIZE", "256")
+			os.Setenv("REPLAY_FROM", "2024-01-01T00:00:00Z")
+			os.Setenv("REPLAY_TO", "2024-01-02T00:00:00Z")
+			os.Setenv("REPLAY_ERROR_TYPES", "http_5xx,network")
 			os.Setenv("HEC_RETRIES", "10")
 			os.Setenv("HEC_WORKERS", "5")
 
@@ -96,10 +149,63 @@ var _ = Describe("Config", func() {
 			Expect(c.BoltDBPath).To(Equal("foo.db"))
 			Expect(c.WantedEvents).To(Equal("LogMessage"))
 			Expect(c.ExtraFields).To(Equal("foo:bar"))
+			Expect(c.IndexedFields).To(Equal("cf_app_name"))
+			Expect(c.Transforms).To(Equal(`[{"action":"drop","field":"job_index"}]`))
+			Expect(c.OutputSchema).To(Equal("otel"))
+			Expect(c.HttpMetricsWindow).To(Equal(time.Minute))
+			Expect(c.HttpMetricsKeepRaw).To(BeTrue())
+			Expect(c.LogMetrics).To(Equal(`[{"name":"orders","type":"counter","regex":"processed"}]`))
+			Expect(c.LogMetricsWindow).To(Equal(30 * time.Second))
+			Expect(c.NativeMetrics).To(BeTrue())
+			Expect(c.AppMetricsWindow).To(Equal(2 * time.Minute))
+			Expect(c.CPUEntitlementPerGB).To(Equal(25.0))
+			Expect(c.QuotaThreshold).To(Equal(5.0))
+			Expect(c.CounterRates).To(BeTrue())
+			Expect(c.CounterRateTTL).To(Equal(10 * time.Minute))
+			Expect(c.SanitizeMessages).To(BeTrue())
+			Expect(c.Lookups).To(Equal("/etc/nozzle/lookups.json"))
+			Expect(c.LookupReloadInterval).To(Equal(time.Minute))
+			Expect(c.BoshMetadata).To(Equal("/etc/nozzle/bosh.json"))
+			Expect(c.BoshDirectorURL).To(Equal("https://bosh.example.com:25555"))
+			Expect(c.BoshUsername).To(Equal("admin"))
+			Expect(c.BoshPassword).To(Equal("secret"))
+			Expect(c.SkipSSLBosh).To(BeTrue())
+			Expect(c.BoshRefreshInterval).To(Equal(time.Hour))
+			Expect(c.MaxMessageSize).To(Equal(65536))
+			Expect(c.OversizedMessagePolicy).To(Equal("split"))
 			Expect(c.AddTags).To(BeTrue())
+			Expect(c.UseCFMetadata).To(BeTrue())
+			Expect(c.AddServiceBindings).To(BeTrue())
+			Expect(c.ServiceBindingCacheTTL).To(Equal(time.Hour))
+			Expect(c.ServiceBindingErrorTTL).To(Equal(5 * time.Minute))
+			Expect(c.HecTenants).To(Equal(`[{"name":"finance","token":"abc"}]`))
 
 			Expect(c.FlushInterval).To(Equal(43 * time.Second))
 			Expect(c.QueueSize).To(Equal(15000))
+			Expect(c.DiskQueueDir).To(Equal("/var/vcap/data/nozzle/queue"))
+			Expect(c.DiskQueueMaxSize).To(Equal(int64(4096)))
+			Expect(c.DiskQueueSegmentSize).To(Equal(int64(128)))
+			Expect(c.DiskQueueSyncPolicy).To(Equal("always"))
+			Expect(c.DiskQueueSyncInterval).To(Equal(5 * time.Second))
+			Expect(c.BatchMaxBytes).To(Equal(1048576))
+			Expect(c.AdaptiveBatching).To(BeTrue())
+			Expect(c.MinBatchSize).To(Equal(50))
+			Expect(c.BatchLatencyTarget).To(Equal(2 * time.Second))
+			Expect(c.BreakerThreshold).To(Equal(5))
+			Expect(c.BreakerProbeInterval).To(Equal(30 * time.Second))
+			Expect(c.BreakerAction).To(Equal("dead-letter"))
+			Expect(c.ParseWorkers).To(Equal(4))
+			Expect(c.SendQueueSize).To(Equal(50))
+			Expect(c.DrainTimeout).To(Equal(8 * time.Second))
+			Expect(c.OrderedDelivery).To(BeTrue())
+			Expect(c.OverflowPolicy).To(Equal("block"))
+			Expect(c.OverflowMaxWait).To(Equal(30 * time.Second))
+			Expect(c.PriorityLanes).To(Equal(`[{"name":"logs","events":["LogMessage"]}]`))
+			Expect(c.DeadLetterDir).To(Equal("/var/vcap/data/nozzle/deadletter"))
+			Expect(c.DeadLetterMaxSize).To(Equal(int64(256)))
+			Expect(c.ReplayFrom).To(Equal("2024-01-01T00:00:00Z"))
+			Expect(c.ReplayTo).To(Equal("2024-01-02T00:00:00Z"))
+			Expect(c.ReplayErrorTypes).To(Equal("http_5xx,network"))
 			Expect(c.BatchSize).To(Equal(100))
 			Expect(c.Retries).To(Equal(10))
 			Expect(c.HecWorkers).To(Equal(5))
@@ -130,13 +236,63 @@ var _ = Describe("Config", func() {
 			Expect(c.AppCacheTTL).To(Equal(0 * time.Second))
 			Expect(c.AppLimits).To(Equal(0))
 			Expect(c.AddTags).To(BeFalse())
+			Expect(c.UseCFMetadata).To(BeFalse())
+			Expect(c.AddServiceBindings).To(BeFalse())
+			Expect(c.ServiceBindingCacheTTL).To(Equal(10 * time.Minute))
+			Expect(c.ServiceBindingErrorTTL).To(Equal(time.Minute))
 
 			Expect(c.BoltDBPath).To(Equal("cache.db"))
 			Expect(c.WantedEvents).To(Equal("ValueMetric,CounterEvent,ContainerMetric"))
 			Expect(c.ExtraFields).To(Equal(""))
+			Expect(c.IndexedFields).To(Equal(""))
+			Expect(c.OutputSchema).To(Equal("default"))
+			Expect(c.HttpMetricsWindow).To(Equal(0 * time.Second))
+			Expect(c.HttpMetricsKeepRaw).To(BeFalse())
+			Expect(c.LogMetrics).To(Equal(""))
+			Expect(c.LogMetricsWindow).To(Equal(time.Minute))
+			Expect(c.NativeMetrics).To(BeFalse())
+			Expect(c.AppMetricsWindow).To(Equal(0 * time.Second))
+			Expect(c.CPUEntitlementPerGB).To(Equal(0.0))
+			Expect(c.QuotaThreshold).To(Equal(0.0))
+			Expect(c.CounterRates).To(BeFalse())
+			Expect(c.CounterRateTTL).To(Equal(5 * time.Minute))
+			Expect(c.SanitizeMessages).To(BeFalse())
+			Expect(c.Lookups).To(Equal(""))
+			Expect(c.LookupReloadInterval).To(Equal(30 * time.Second))
+			Expect(c.BoshMetadata).To(Equal(""))
+			Expect(c.BoshDirectorURL).To(Equal(""))
+			Expect(c.SkipSSLBosh).To(BeFalse())
+			Expect(c.BoshRefreshInterval).To(Equal(10 * time.Minute))
+			Expect(c.MaxMessageSize).To(Equal(0))
+			Expect(c.OversizedMessagePolicy).To(Equal("truncate"))
 
 			Expect(c.FlushInterval).To(Equal(5 * time.Second))
 			Expect(c.QueueSize).To(Equal(10000))
+			Expect(c.DiskQueueDir).To(Equal(""))
+			Expect(c.DiskQueueMaxSize).To(Equal(int64(1024)))
+			Expect(c.DiskQueueSegmentSize).To(Equal(int64(64)))
+			Expect(c.DiskQueueSyncPolicy).To(Equal("interval"))
+			Expect(c.DiskQueueSyncInterval).To(Equal(time.Second))
+			Expect(c.BatchMaxBytes).To(Equal(0))
+			Expect(c.AdaptiveBatching).To(BeFalse())
+			Expect(c.MinBatchSize).To(Equal(10))
+			Expect(c.BatchLatencyTarget).To(Equal(time.Second))
+			Expect(c.BreakerThreshold).To(Equal(0))
+			Expect(c.BreakerProbeInterval).To(Equal(10 * time.Second))
+			Expect(c.BreakerAction).To(Equal("pause"))
+			Expect(c.ParseWorkers).To(Equal(0))
+			Expect(c.SendQueueSize).To(Equal(100))
+			Expect(c.DrainTimeout).To(Equal(time.Duration(0)))
+			Expect(c.OrderedDelivery).To(BeFalse())
+			Expect(c.OverflowPolicy).To(Equal("drop-newest"))
+			Expect(c.OverflowMaxWait).To(Equal(time.Duration(0)))
+			Expect(c.PriorityLanes).To(Equal(""))
+			Expect(c.DeadLetterDir).To(Equal(""))
+			Expect(c.DeadLetterMaxSize).To(Equal(int64(1024)))
+			Expect(c.ReplayFrom).To(Equal(""))
+			Expect(c.ReplayTo).To(Equal(""))
+			Expect(c.ReplayErrorTypes).To(Equal(""))
+			Expect(c.Command).To(Equal(CommandRun))
 			Expect(c.BatchSize).To(Equal(100))
 			Expect(c.Retries).To(Equal(5))
 			Expect(c.HecWorkers).To(Equal(8))
@@ -181,7 +337,11 @@ var _ = Describe("Config", func() {
 				"--boltdb-path=foo.dbc",
 				"--events=LogMessagec",
 				"--add-tags",
+				"--use-cf-metadata",
 				"--extra-fields=foo:barc",
+				"--output-schema=ecs",
+				"--http-metrics-window=30s",
+				"--native-metrics",
 				"--flush-interval=34s",
 				"--consumer-queue-size=2323",
 				"--hec-batch-size=1234",
@@ -224,7 +384,11 @@ var _ = Describe("Config", func() {
 			Expect(c.BoltDBPath).To(Equal("foo.dbc"))
 			Expect(c.WantedEvents).To(Equal("LogMessagec"))
 			Expect(c.ExtraFields).To(Equal("foo:barc"))
+			Expect(c.OutputSchema).To(Equal("ecs"))
+			Expect(c.HttpMetricsWindow).To(Equal(30 * time.Second))
+			Expect(c.NativeMetrics).To(BeTrue())
 			Expect(c.AddTags).To(BeTrue())
+			Expect(c.UseCFMetadata).To(BeTrue())
 
 			Expect(c.FlushInterval).To(Equal(34 * time.Second))
 			Expect(c.QueueSize).To(Equal(2323))
@@ -1,13 +1,18 @@
 package splunknozzle
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"strings"
 	"time"
 
 	"code.cloudfoundry.org/lager"
 	cfclient "github.com/cloudfoundry-community/go-cfclient"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/bosh"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/deadletter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/diskqueue"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
@@ -23,8 +28,11 @@ import (
 )
 
 type SplunkFirehoseNozzle struct {
-	config *Config
-	logger lager.Logger
+	config       *Config
+	logger       lager.Logger
+	boshMetadata *bosh.Metadata
+
+	serviceBindings *cache.ServiceBindings
 }
 
 // create new function of type *SplunkFirehoseNozzle
@@ -73,6 +81,7 @@ func (s *SplunkFirehoseNozzle) AppCache(client cache.AppClient) (cache.Cache, er
 			MissingAppCacheTTL: s.config.MissingAppCacheTTL,
 			AppCacheTTL:        s.config.AppCacheTTL,
 			OrgSpaceCacheTTL:   s.config.OrgSpaceCacheTTL,
+			UseMetadata:        s.config.UseCFMetadata,
 			Logger:             s.logger,
 		}
 		return cache.NewBoltdb(client, &c)
@@ -81,11 +90,39 @@ func (s *SplunkFirehoseNozzle) AppCache(client cache.AppClient) (cache.Cache, er
 	return cache.NewNoCache(), nil
 }
 
-// EventSink creates std sink or Splunk sink
-func (s *SplunkFirehoseNozzle) EventSink(cache cache.Cache) (eventsink.Sink, error) {
+// ServiceBindings creates the service bindings cache when enabled
+func (s *SplunkFirehoseNozzle) ServiceBindings(client cache.ServiceBindingClient) *cache.ServiceBindings {
+	if !s.config.AddServiceBindings {
+		return nil
+	}
 
-	// EventWriter for writing events
-	writerConfig := &eventwriter.SplunkConfig{
+	return cache.NewServiceBindings(client, &cache.ServiceBindingsConfig{
+		TTL:      s.config.ServiceBindingCacheTTL,
+		ErrorTTL: s.config.ServiceBindingErrorTTL,
+		Logger:   s.logger,
+	})
+}
+
+// BoshMetadata creates the BOSH deployment and instance metadata when configured
+func (s *SplunkFirehoseNozzle) BoshMetadata() (*bosh.Metadata, error) {
+	if s.config.BoshMetadata == "" && s.config.BoshDirectorURL == "" {
+		return nil, nil
+	}
+
+	return bosh.NewMetadata(&bosh.Config{
+		DirectorURL:     s.config.BoshDirectorURL,
+		Username:        s.config.BoshUsername,
+		Password:        s.config.BoshPassword,
+		SkipSSL:         s.config.SkipSSLBosh,
+		RefreshInterval: s.config.BoshRefreshInterval,
+		Static:          s.config.BoshMetadata,
+		Logger:          s.logger,
+	})
+}
+
+// writerConfig is the config of the default HEC destination
+func (s *SplunkFirehoseNozzle) writerConfig() *eventwriter.SplunkConfig {
+	return &eventwriter.SplunkConfig{
 		Host:                    s.config.SplunkHost,
 		Token:                   s.config.SplunkToken,
 		Index:                   s.config.SplunkIndex,
@@ -96,6 +133,30 @@ func (s *SplunkFirehoseNozzle) EventSink(cache cache.Cache) (eventsink.Sink, err
 		RefreshSplunkConnection: s.config.RefreshSplunkConnection,
 		KeepAliveTimer:          s.config.KeepAliveTimer,
 	}
+}
+
+// metricWriterConfig is the config of the metrics index destination
+func (s *SplunkFirehoseNozzle) metricWriterConfig(writerConfig *eventwriter.SplunkConfig) *eventwriter.SplunkConfig {
+	metricConfig := *writerConfig
+	metricConfig.Index = s.config.SplunkMetricIndex
+	return &metricConfig
+}
+
+// tenantWriterConfig is the config of the tenant's HEC token and host
+func tenantWriterConfig(writerConfig *eventwriter.SplunkConfig, tenant eventsink.Tenant) *eventwriter.SplunkConfig {
+	tenantConfig := *writerConfig
+	tenantConfig.Token = tenant.Token
+	if tenant.Host != "" {
+		tenantConfig.Host = strings.TrimRight(strings.TrimSpace(tenant.Host), "/")
+	}
+	return &tenantConfig
+}
+
+// EventSink creates std sink or Splunk sink
+func (s *SplunkFirehoseNozzle) EventSink(cache cache.Cache) (eventsink.Sink, error) {
+
+	// EventWriter for writing events
+	writerConfig := s.writerConfig()
 
 	var writers []eventwriter.Writer
 	for i := 0; i < s.config.HecWorkers+1; i++ {
@@ -111,6 +172,126 @@ func (s *SplunkFirehoseNozzle) EventSink(cache cache.Cache) (eventsink.Sink, err
 		return nil, err
 	}
 
+	indexedFields, err := events.ParseIndexedFields(s.config.IndexedFields)
+	if err != nil {
+		s.logger.Error("Error at parsing indexed fields", nil)
+		return nil, err
+	}
+
+	transforms, err := events.ParseTransforms(s.config.Transforms)
+	if err != nil {
+		s.logger.Error("Error at parsing transforms", nil)
+		return nil, err
+	}
+
+	// Schema renames run first so user transforms can refer to the schema's field names
+	schemaTransforms, err := events.SchemaTransforms(s.config.OutputSchema)
+	if err != nil {
+		s.logger.Error("Error at parsing output schema", nil)
+		return nil, err
+	}
+	transforms = append(schemaTransforms[:len(schemaTransforms):len(schemaTransforms)], transforms...)
+
+	lookups, err := events.NewLookups(s.config.Lookups)
+	if err != nil {
+		s.logger.Error("Error at loading lookups", err)
+		return nil, err
+	}
+
+	tenants, err := eventsink.ParseTenants(s.config.HecTenants)
+	if err != nil {
+		s.logger.Error("Error at parsing HEC tenants", nil)
+		return nil, err
+	}
+
+	lanes, err := eventsink.ParseLanes(s.config.PriorityLanes)
+	if err != nil {
+		s.logger.Error("Error at parsing priority lanes", nil)
+		return nil, err
+	}
+	if len(lanes) > s.config.HecWorkers {
+		err := fmt.Errorf("%d priority lanes need at least %d HEC workers", len(lanes), len(lanes))
+		s.logger.Error("Error at configuring priority lanes", err)
+		return nil, err
+	}
+	if s.config.ParseWorkers > 0 && len(lanes) > s.config.ParseWorkers {
+		err := fmt.Errorf("%d priority lanes need at least %d parse workers", len(lanes), len(lanes))
+		s.logger.Error("Error at configuring parse workers", err)
+		return nil, err
+	}
+	if len(lanes) > 0 && s.config.DiskQueueDir != "" {
+		err := errors.New("priority lanes can't be used with the persistent queue")
+		s.logger.Error("Error at configuring priority lanes", err)
+		return nil, err
+	}
+	if s.config.OrderedDelivery && s.config.ParseWorkers > 0 {
+		err := errors.New("ordered delivery requires parsing in the HEC workers, without parse workers")
+		s.logger.Error("Error at configuring ordered delivery", err)
+		return nil, err
+	}
+
+	logMetricRules, err := events.ParseLogMetricRules(s.config.LogMetrics)
+	if err != nil {
+		s.logger.Error("Error at parsing log metric rules", nil)
+		return nil, err
+	}
+
+	var metricWriter eventwriter.Writer
+	if s.config.HttpMetricsWindow > 0 || len(logMetricRules) > 0 || s.config.NativeMetrics || s.config.AppMetricsWindow > 0 {
+		if s.config.SplunkMetricIndex == "" {
+			err := errors.New("HTTP, log, app and native metrics require a Splunk metric index")
+			s.logger.Error("Error at configuring metrics", err)
+			return nil, err
+		}
+		metricWriter = eventwriter.NewSplunkMetric(s.metricWriterConfig(writerConfig))
+	}
+
+	var counterRateTTL time.Duration
+	if s.config.CounterRates {
+		counterRateTTL = s.config.CounterRateTTL
+	}
+
+	var deadLetters *deadletter.Store
+	if s.config.DeadLetterDir != "" {
+		deadLetters, err = deadletter.NewStore(s.config.DeadLetterDir, s.config.DeadLetterMaxSize*1024*1024)
+		if err != nil {
+			s.logger.Error("Error at opening dead-letter store", err)
+			return nil, err
+		}
+	}
+	if s.config.BreakerThreshold > 0 && s.config.BreakerAction == eventsink.BreakerActionDeadLetter && deadLetters == nil {
+		err := errors.New("the dead-letter circuit breaker action requires a dead-letter directory")
+		s.logger.Error("Error at configuring circuit breaker", err)
+		return nil, err
+	}
+	var newHealthCheck func(eventsink.Tenant) func() error
+	if !s.config.Debug {
+		// Each circuit breaker probes the HEC host and token of its tenant
+		newHealthCheck = func(tenant eventsink.Tenant) func() error {
+			healthConfig := writerConfig
+			if tenant.Token != "" {
+				healthConfig = tenantWriterConfig(writerConfig, tenant)
+			}
+			return eventwriter.NewSplunkEvent(healthConfig).(*eventwriter.SplunkEvent).CheckHealth
+		}
+	}
+
+	var queue *diskqueue.Queue
+	if s.config.DiskQueueDir != "" {
+		queue, err = diskqueue.Open(&diskqueue.Config{
+			Dir:          s.config.DiskQueueDir,
+			MaxSize:      s.config.DiskQueueMaxSize * 1024 * 1024,
+			SegmentSize:  s.config.DiskQueueSegmentSize * 1024 * 1024,
+			SyncPolicy:   s.config.DiskQueueSyncPolicy,
+			SyncInterval: s.config.DiskQueueSyncInterval,
+			Logger:       s.logger,
+		})
+		if err != nil {
+			s.logger.Error("Error at opening disk queue", err)
+			return nil, err
+		}
+	}
+
 	nozzleUUID := uuid.New().String()
 
 	sinkConfig := &eventsink.SplunkConfig{
@@ -118,16 +299,57 @@ func (s *SplunkFirehoseNozzle) EventSink(cache cache.Cache) (eventsink.Sink, err
 		QueueSize:               s.config.QueueSize,
 		BatchSize:               s.config.BatchSize,
 		Retries:                 s.config.Retries,
+		MaxBatchBytes:           s.config.BatchMaxBytes,
+		AdaptiveBatching:        s.config.AdaptiveBatching,
+		MinBatchSize:            s.config.MinBatchSize,
+		BatchLatencyTarget:      s.config.BatchLatencyTarget,
 		Hostname:                s.config.JobHost,
 		SubscriptionID:          s.config.SubscriptionID,
 		TraceLogging:            s.config.TraceLogging,
 		ExtraFields:             parsedExtraFields,
+		IndexedFields:           indexedFields,
+		Transforms:              transforms,
 		UUID:                    nozzleUUID,
 		Logger:                  s.logger,
 		LoggingIndex:            s.config.SplunkLoggingIndex,
 		StatusMonitorInterval:   s.config.StatusMonitorInterval,
 		RefreshSplunkConnection: s.config.RefreshSplunkConnection,
 		KeepAliveTimer:          s.config.KeepAliveTimer,
+		Tenants:                 tenants,
+		HttpMetricsWindow:       s.config.HttpMetricsWindow,
+		HttpMetricsKeepRaw:      s.config.HttpMetricsKeepRaw,
+		LogMetricRules:          logMetricRules,
+		LogMetricsWindow:        s.config.LogMetricsWindow,
+		NativeMetrics:           s.config.NativeMetrics,
+		AppMetricsWindow:        s.config.AppMetricsWindow,
+		CounterRateTTL:          counterRateTTL,
+		Lookups:                 lookups,
+		BoshMetadata:            s.boshMetadata,
+		ServiceBindings:         s.serviceBindings,
+		LookupReloadInterval:    s.config.LookupReloadInterval,
+		SanitizeMessages:        s.config.SanitizeMessages,
+		MaxMessageSize:          s.config.MaxMessageSize,
+		OversizedMessagePolicy:  s.config.OversizedMessagePolicy,
+		MetricWriter:            metricWriter,
+		Queue:                   queue,
+		DeadLetters:             deadLetters,
+		BreakerThreshold:        s.config.BreakerThreshold,
+		BreakerProbeInterval:    s.config.BreakerProbeInterval,
+		BreakerAction:           s.config.BreakerAction,
+		NewHealthCheck:          newHealthCheck,
+		ParseWorkers:            s.config.ParseWorkers,
+		SendQueueSize:           s.config.SendQueueSize,
+		DrainTimeout:            s.config.DrainTimeout,
+		OrderedDelivery:         s.config.OrderedDelivery,
+		OverflowPolicy:          s.config.OverflowPolicy,
+		OverflowMaxWait:         s.config.OverflowMaxWait,
+		Lanes:                   lanes,
+		NewTenantWriter: func(tenant eventsink.Tenant) eventwriter.Writer {
+			splunkWriter := eventwriter.NewSplunkEvent(tenantWriterConfig(writerConfig, tenant)).(*eventwriter.SplunkEvent)
+			splunkWriter.SentEventCount = monitoring.RegisterCounter("splunk.events.sent.count", utils.UintType)
+			splunkWriter.BodyBufferSize = monitoring.RegisterCounter("splunk.events.throughput", utils.UintType)
+			return splunkWriter
+		},
 	}
 
 	LowerAddAppInfo := strings.ToLower(s.config.AddAppInfo)
@@ -139,10 +361,19 @@ func (s *SplunkFirehoseNozzle) EventSink(cache cache.Cache) (eventsink.Sink, err
 		AddSpaceName:   strings.Contains(LowerAddAppInfo, "spacename"),
 		AddSpaceGuid:   strings.Contains(LowerAddAppInfo, "spaceguid"),
 		AddTags:        s.config.AddTags,
+
+		CPUEntitlementPerGB: s.config.CPUEntitlementPerGB,
+		QuotaThreshold:      s.config.QuotaThreshold,
 	}
 
 	splunkSink := eventsink.NewSplunk(writers, sinkConfig, parseConfig, cache)
-	splunkSink.Open()
+	if err := splunkSink.Open(); err != nil {
+		s.logger.Error("Error at opening Splunk sink", err)
+		if queue != nil {
+			queue.Close()
+		}
+		return nil, err
+	}
 
 	s.logger.RegisterSink(splunkSink)
 	if s.config.StatusMonitorInterval > time.Second*0 {
@@ -228,6 +459,18 @@ func (s *SplunkFirehoseNozzle) Run(shutdownChan chan os.Signal) error {
 	}
 	defer appCache.Close()
 
+	s.serviceBindings = s.ServiceBindings(pcfClient)
+
+	s.boshMetadata, err = s.BoshMetadata()
+	if err != nil {
+		s.logger.Error("Failed to create BOSH metadata", nil)
+		return err
+	}
+	if s.boshMetadata != nil {
+		s.boshMetadata.Open()
+		defer s.boshMetadata.Close()
+	}
+
 	eventSink, err := s.EventSink(appCache)
 	if err != nil {
 		s.logger.Error("Failed to create event sink", nil)
@@ -260,6 +503,14 @@ func (s *SplunkFirehoseNozzle) Run(shutdownChan chan os.Signal) error {
 
 	s.logger.Info("Splunk Nozzle is going to exit gracefully")
 	metric.Stop()
+	return s.Shutdown(noz, eventSink)
+}
+
+// Shutdown stops reading the firehose and drains the event sink. Writes
+// blocked on a full queue are released first, otherwise the firehose consumer
+// would wait on them while HEC is unreachable.
+func (s *SplunkFirehoseNozzle) Shutdown(noz *nozzle.Nozzle, eventSink eventsink.Sink) error {
+	eventSink.Stop()
 	noz.Close()
 	return eventSink.Close()
 }
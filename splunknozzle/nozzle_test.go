@@ -87,6 +87,56 @@ var _ = Describe("SplunkFirehoseNozzle", func() {
 		Ω(err).ShouldNot(HaveOccurred())
 	})
 
+	It("EventSink with priority lanes", func() {
+		c := testing.NewMemoryCacheMock()
+		config.PriorityLanes = `[{"name":"logs","events":["LogMessage"]},{"name":"metrics","events":["ValueMetric"]}]`
+		_, err := noz.EventSink(c)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		config.HecWorkers = 1
+		_, err = noz.EventSink(c)
+		Ω(err).Should(HaveOccurred())
+
+		config.PriorityLanes = `[{"name":"logs","events":["NoSuchEvent"]}]`
+		_, err = noz.EventSink(c)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("EventSink with priority lanes and the persistent queue", func() {
+		c := testing.NewMemoryCacheMock()
+		dir, err := os.MkdirTemp("", "nozzle")
+		Ω(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		config.DiskQueueDir = dir
+		config.PriorityLanes = `[{"name":"logs","events":["LogMessage"]},{"name":"metrics","events":["ValueMetric"]}]`
+		_, err = noz.EventSink(c)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("EventSink with the dead-letter circuit breaker action", func() {
+		c := testing.NewMemoryCacheMock()
+		config.BreakerThreshold = 3
+		config.BreakerAction = "dead-letter"
+		_, err := noz.EventSink(c)
+		Ω(err).Should(HaveOccurred())
+
+		config.DeadLetterDir = os.TempDir() + "/nozzle-deadletter"
+		defer os.RemoveAll(config.DeadLetterDir)
+		_, err = noz.EventSink(c)
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("EventSink with ordered delivery", func() {
+		c := testing.NewMemoryCacheMock()
+		config.OrderedDelivery = true
+		_, err := noz.EventSink(c)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		config.ParseWorkers = 2
+		_, err = noz.EventSink(c)
+		Ω(err).Should(HaveOccurred())
+	})
+
 	It("PCFClient", func() {
 		port := 9911
 		cc := testing.NewCloudControllerMock(port)
@@ -153,6 +203,33 @@ var _ = Describe("SplunkFirehoseNozzle", func() {
 		Expect(n).ToNot(BeNil())
 	})
 
+	It("Shutdown while HEC is unreachable", func() {
+		config.SplunkHost = "http://localhost:1"
+		config.WantedEvents = "ValueMetric"
+		config.HecWorkers = 1
+		config.QueueSize = 1
+		config.BatchSize = 1
+		config.OverflowPolicy = "block"
+		config.DrainTimeout = 100 * time.Millisecond
+		config.StatusMonitorInterval = 0
+		c := testing.NewMemoryCacheMock()
+		eventSink, err := noz.EventSink(c)
+		Ω(err).ShouldNot(HaveOccurred())
+		router, err := noz.EventRouter(c, eventSink)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		// The firehose consumer blocks on the full queue while the HEC worker retries
+		n := noz.Nozzle(testing.NewMemoryEventSourceMock(-1, -1, -1), router)
+		go n.Start()
+		time.Sleep(100 * time.Millisecond)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- noz.Shutdown(n, eventSink)
+		}()
+		Eventually(done, 5*time.Second).Should(Receive(BeNil()))
+	})
+
 	It("Run without cloudcontroller, error out", func() {
 		shutdownChan := make(chan os.Signal, 2)
 		err := noz.Run(shutdownChan)
@@ -0,0 +1,133 @@
+package splunknozzle
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/deadletter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
+)
+
+// Replay re-sends the dead-letter batches matching the replay filter to their
+// destination. Batches are removed from the store once sent, batches which
+// fail again are kept for a later replay.
+func (s *SplunkFirehoseNozzle) Replay() error {
+	if s.config.DeadLetterDir == "" {
+		return errors.New("replay requires a dead-letter directory")
+	}
+
+	filter, err := s.ReplayFilter()
+	if err != nil {
+		return err
+	}
+
+	tenants, err := eventsink.ParseTenants(s.config.HecTenants)
+	if err != nil {
+		return err
+	}
+
+	store, err := deadletter.NewStore(s.config.DeadLetterDir, 0)
+	if err != nil {
+		return err
+	}
+	paths, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	writerConfig := s.writerConfig()
+	writers := make(map[string]eventwriter.Writer)
+	writerOf := func(record *deadletter.Record) (eventwriter.Writer, error) {
+		key := "tenant:" + record.Tenant
+		if record.Metrics {
+			key = "metrics"
+		}
+		if w, ok := writers[key]; ok {
+			return w, nil
+		}
+
+		var w eventwriter.Writer
+		switch {
+		case record.Metrics:
+			if s.config.SplunkMetricIndex == "" {
+				return nil, errors.New("replaying metrics requires a Splunk metric index")
+			}
+			w = eventwriter.NewSplunkMetric(s.metricWriterConfig(writerConfig))
+		case record.Tenant != "":
+			for _, tenant := range tenants {
+				if tenant.Name == record.Tenant {
+					w = eventwriter.NewSplunkEvent(tenantWriterConfig(writerConfig, tenant))
+				}
+			}
+			if w == nil {
+				return nil, fmt.Errorf("unknown HEC tenant [%s]", record.Tenant)
+			}
+		default:
+			w = eventwriter.NewSplunkEvent(writerConfig)
+		}
+		writers[key] = w
+		return w, nil
+	}
+
+	var sent, failed, events int
+	for _, path := range paths {
+		record, err := store.Load(path)
+		if err != nil {
+			s.logger.Error("Unable to load dead-letter batch", err)
+			failed++
+			continue
+		}
+		if !filter.Matches(record) {
+			continue
+		}
+
+		writer, err := writerOf(record)
+		if err == nil {
+			err, _ = writer.Write(record.Events)
+		}
+		if err != nil {
+			s.logger.Error("Unable to replay dead-letter batch", err, lager.Data{"file": path})
+			failed++
+			continue
+		}
+
+		if err := store.Remove(path); err != nil {
+			s.logger.Error("Unable to remove replayed dead-letter batch", err, lager.Data{"file": path})
+		}
+		sent++
+		events += len(record.Events)
+	}
+
+	s.logger.Info("Finished replaying dead-letter batches", lager.Data{"batches": sent, "events": events, "failed": failed})
+	if failed > 0 {
+		return fmt.Errorf("failed to replay %d dead-letter batches", failed)
+	}
+	return nil
+}
+
+// ReplayFilter parses the time range and error types of the batches to replay
+func (s *SplunkFirehoseNozzle) ReplayFilter() (*deadletter.Filter, error) {
+	filter := &deadletter.Filter{}
+
+	var err error
+	if s.config.ReplayFrom != "" {
+		if filter.From, err = time.Parse(time.RFC3339, s.config.ReplayFrom); err != nil {
+			return nil, fmt.Errorf("invalid replay start time: %s", err)
+		}
+	}
+	if s.config.ReplayTo != "" {
+		if filter.To, err = time.Parse(time.RFC3339, s.config.ReplayTo); err != nil {
+			return nil, fmt.Errorf("invalid replay end time: %s", err)
+		}
+	}
+	for _, errorType := range strings.Split(s.config.ReplayErrorTypes, ",") {
+		if errorType = strings.TrimSpace(errorType); errorType != "" {
+			filter.ErrorTypes = append(filter.ErrorTypes, errorType)
+		}
+	}
+	return filter, nil
+}
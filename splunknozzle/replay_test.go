@@ -0,0 +1,116 @@
+package splunknozzle_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/deadletter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
+	. "github.com/cloudfoundry-community/splunk-firehose-nozzle/splunknozzle"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Replay", func() {
+	var (
+		config   *Config
+		server   *httptest.Server
+		requests int32
+		status   int
+		store    *deadletter.Store
+	)
+
+	events := []map[string]interface{}{{"event": map[string]interface{}{"msg": "hello"}}}
+
+	BeforeEach(func() {
+		requests = 0
+		status = http.StatusOK
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.WriteHeader(status)
+		}))
+
+		dir, err := os.MkdirTemp("", "replay")
+		Ω(err).ShouldNot(HaveOccurred())
+		store, err = deadletter.NewStore(dir, 0)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		config = newConfig()
+		config.SplunkHost = server.URL
+		config.DeadLetterDir = dir
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(config.DeadLetterDir)
+	})
+
+	replay := func() error {
+		return NewSplunkFirehoseNozzle(config, lager.NewLogger("test")).Replay()
+	}
+
+	It("replays the batches matching the error types", func() {
+		store.Add(deadletter.NewRecord(events, &eventwriter.HECError{StatusCode: 503}))
+		store.Add(deadletter.NewRecord(events, errors.New("connection refused")))
+		config.ReplayErrorTypes = "http_5xx"
+
+		Ω(replay()).ShouldNot(HaveOccurred())
+		Expect(atomic.LoadInt32(&requests)).To(Equal(int32(1)))
+
+		paths, _ := store.List()
+		Expect(paths).To(HaveLen(1))
+		record, _ := store.Load(paths[0])
+		Expect(record.ErrorType).To(Equal(deadletter.ErrorTypeNetwork))
+	})
+
+	It("replays the batches of the time range", func() {
+		old := deadletter.NewRecord(events, errors.New("connection refused"))
+		old.Time = time.Now().Add(-time.Hour)
+		store.Add(old)
+		store.Add(deadletter.NewRecord(events, errors.New("connection refused")))
+		config.ReplayFrom = time.Now().Add(-time.Minute).Format(time.RFC3339)
+
+		Ω(replay()).ShouldNot(HaveOccurred())
+		Expect(atomic.LoadInt32(&requests)).To(Equal(int32(1)))
+		paths, _ := store.List()
+		Expect(paths).To(HaveLen(1))
+	})
+
+	It("keeps the batches which fail again", func() {
+		store.Add(deadletter.NewRecord(events, errors.New("connection refused")))
+		status = http.StatusServiceUnavailable
+
+		Ω(replay()).Should(HaveOccurred())
+		paths, _ := store.List()
+		Expect(paths).To(HaveLen(1))
+	})
+
+	It("keeps the batches of unknown tenants", func() {
+		record := deadletter.NewRecord(events, errors.New("connection refused"))
+		record.Tenant = "org-a"
+		store.Add(record)
+
+		Ω(replay()).Should(HaveOccurred())
+		Expect(atomic.LoadInt32(&requests)).To(BeZero())
+		paths, _ := store.List()
+		Expect(paths).To(HaveLen(1))
+	})
+
+	It("requires a dead-letter directory", func() {
+		config.DeadLetterDir = ""
+		Ω(replay()).Should(HaveOccurred())
+	})
+
+	It("rejects invalid replay times", func() {
+		config.ReplayTo = "yesterday"
+		_, err := NewSplunkFirehoseNozzle(config, lager.NewLogger("test")).ReplayFilter()
+		Ω(err).Should(HaveOccurred())
+	})
+})
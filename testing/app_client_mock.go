@@ -12,6 +12,11 @@ import (
 type AppClientMock struct {
 	lock                    sync.RWMutex
 	apps                    map[string]cfclient.App
+	appLabels               map[string]map[string]string
+	spaceLabels             map[string]map[string]string
+	orgLabels               map[string]map[string]string
+	metadataErr             error
+	appMetadataErr          error
 	n                       int
 	listAppsCallCount       int
 	appByGUIDCallCount      int
@@ -22,8 +27,11 @@ type AppClientMock struct {
 func NewAppClientMock(n int) *AppClientMock {
 	apps := getApps(n)
 	return &AppClientMock{
-		apps: apps,
-		n:    n,
+		apps:        apps,
+		appLabels:   make(map[string]map[string]string),
+		spaceLabels: make(map[string]map[string]string),
+		orgLabels:   make(map[string]map[string]string),
+		n:           n,
 	}
 }
 
@@ -88,6 +96,102 @@ func (m *AppClientMock) GetOrgByGuid(orgGUID string) (cfclient.Org, error) {
 	}, nil
 }
 
+func (m *AppClientMock) ListV3AppsByQuery(query url.Values) ([]cfclient.V3App, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if m.appMetadataErr != nil {
+		return nil, m.appMetadataErr
+	}
+	var apps []cfclient.V3App
+	for guid, app := range m.apps {
+		apps = append(apps, cfclient.V3App{
+			GUID:     guid,
+			Name:     app.Name,
+			Metadata: cfclient.V3Metadata{Labels: m.appLabels[guid]},
+		})
+	}
+	return apps, nil
+}
+
+func (m *AppClientMock) GetV3AppByGUID(guid string) (*cfclient.V3App, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if m.appMetadataErr != nil {
+		return nil, m.appMetadataErr
+	}
+	app, ok := m.apps[guid]
+	if !ok {
+		return nil, errors.New("No such app")
+	}
+	return &cfclient.V3App{
+		GUID:     guid,
+		Name:     app.Name,
+		Metadata: cfclient.V3Metadata{Labels: m.appLabels[guid]},
+	}, nil
+}
+
+func (m *AppClientMock) SpaceMetadata(spaceGUID string) (*cfclient.Metadata, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if m.metadataErr != nil {
+		return nil, m.metadataErr
+	}
+	return toMetadata(m.spaceLabels[spaceGUID]), nil
+}
+
+func (m *AppClientMock) OrgMetadata(orgGUID string) (*cfclient.Metadata, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if m.metadataErr != nil {
+		return nil, m.metadataErr
+	}
+	return toMetadata(m.orgLabels[orgGUID]), nil
+}
+
+// SetMetadataError makes the org and space metadata calls fail, e.g. on older CAPI
+func (m *AppClientMock) SetMetadataError(err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.metadataErr = err
+}
+
+// SetAppMetadataError makes the v3 app calls fail
+func (m *AppClientMock) SetAppMetadataError(err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.appMetadataErr = err
+}
+
+func (m *AppClientMock) SetAppLabels(appID string, labels map[string]string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.appLabels[appID] = labels
+}
+
+func (m *AppClientMock) SetSpaceLabels(spaceID string, labels map[string]string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.spaceLabels[spaceID] = labels
+}
+
+func (m *AppClientMock) SetOrgLabels(orgID string, labels map[string]string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.orgLabels[orgID] = labels
+}
+
+func toMetadata(labels map[string]string) *cfclient.Metadata {
+	md := &cfclient.Metadata{Labels: map[string]interface{}{}}
+	for k, v := range labels {
+		md.Labels[k] = v
+	}
+	return md
+}
+
 func (m *AppClientMock) CreateApp(appID, spaceID string) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
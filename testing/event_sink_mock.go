@@ -21,6 +21,9 @@ func (l *MemorySinkMock) Open() error {
 	return nil
 }
 
+func (l *MemorySinkMock) Stop() {
+}
+
 func (l *MemorySinkMock) Close() error {
 	return nil
 }
@@ -5,7 +5,10 @@ import (
 )
 
 type MemoryCacheMock struct {
-	ignoreApp bool
+	ignoreApp   bool
+	metadata    map[string]string
+	appMetadata map[string]map[string]string
+	appEnv      map[string]interface{}
 }
 
 func NewMemoryCacheMock() *MemoryCacheMock {
@@ -33,6 +36,12 @@ func (c *MemoryCacheMock) GetApp(appGuid string) (*cache.App, error) {
 		OrgName:    "testing-org",
 		OrgGuid:    "f964a41c-76ac-42c1-b2ba-663da3ec22d7",
 		IgnoredApp: c.ignoreApp,
+		Metadata:   c.metadata,
+		CfAppEnv:   c.appEnv,
+	}
+	if metadata, ok := c.appMetadata[appGuid]; ok {
+		app.Guid = appGuid
+		app.Metadata = metadata
 	}
 
 	return app, nil
@@ -41,3 +50,19 @@ func (c *MemoryCacheMock) GetApp(appGuid string) (*cache.App, error) {
 func (c *MemoryCacheMock) SetIgnoreApp(ignore bool) {
 	c.ignoreApp = ignore
 }
+
+func (c *MemoryCacheMock) SetMetadata(metadata map[string]string) {
+	c.metadata = metadata
+}
+
+// SetAppMetadata overrides the metadata of the app
+func (c *MemoryCacheMock) SetAppMetadata(appGuid string, metadata map[string]string) {
+	if c.appMetadata == nil {
+		c.appMetadata = make(map[string]map[string]string)
+	}
+	c.appMetadata[appGuid] = metadata
+}
+
+func (c *MemoryCacheMock) SetAppEnv(appEnv map[string]interface{}) {
+	c.appEnv = appEnv
+}
@@ -0,0 +1,124 @@
+package testing
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+
+	cfclient "github.com/cloudfoundry-community/go-cfclient"
+)
+
+type ServiceBindingClientMock struct {
+	lock                  sync.RWMutex
+	bindings              map[string][]string // app guid -> service instance guids
+	instances             map[string]cfclient.ServiceInstance
+	userProvided          map[string]cfclient.UserProvidedServiceInstance
+	fail                  bool
+	listBindingsCallCount int
+	getInstanceCallCount  int
+}
+
+func NewServiceBindingClientMock() *ServiceBindingClientMock {
+	return &ServiceBindingClientMock{
+		bindings:     make(map[string][]string),
+		instances:    make(map[string]cfclient.ServiceInstance),
+		userProvided: make(map[string]cfclient.UserProvidedServiceInstance),
+	}
+}
+
+// CreateServiceInstance creates a managed service instance of offering
+// "<offering>" and plan "<plan>"
+func (m *ServiceBindingClientMock) CreateServiceInstance(guid, name, offering, plan string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.instances[guid] = cfclient.ServiceInstance{
+		Guid:            guid,
+		Name:            name,
+		ServiceGuid:     offering,
+		ServicePlanGuid: plan,
+	}
+}
+
+func (m *ServiceBindingClientMock) CreateUserProvidedServiceInstance(guid, name string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.userProvided[guid] = cfclient.UserProvidedServiceInstance{Guid: guid, Name: name}
+}
+
+func (m *ServiceBindingClientMock) Bind(appGuid, serviceInstanceGuid string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.bindings[appGuid] = append(m.bindings[appGuid], serviceInstanceGuid)
+}
+
+// SetFail makes every request fail
+func (m *ServiceBindingClientMock) SetFail(fail bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.fail = fail
+}
+
+func (m *ServiceBindingClientMock) ListServiceBindingsByQuery(query url.Values) ([]cfclient.ServiceBinding, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.listBindingsCallCount++
+	if m.fail {
+		return nil, errors.New("Failed to list service bindings")
+	}
+
+	appGuid := strings.TrimPrefix(query.Get("q"), "app_guid:")
+	var bindings []cfclient.ServiceBinding
+	for _, guid := range m.bindings[appGuid] {
+		bindings = append(bindings, cfclient.ServiceBinding{AppGuid: appGuid, ServiceInstanceGuid: guid})
+	}
+	return bindings, nil
+}
+
+func (m *ServiceBindingClientMock) GetServiceInstanceByGuid(guid string) (cfclient.ServiceInstance, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.getInstanceCallCount++
+	instance, ok := m.instances[guid]
+	if m.fail || !ok {
+		return instance, errors.New("No such service instance")
+	}
+	return instance, nil
+}
+
+func (m *ServiceBindingClientMock) GetUserProvidedServiceInstanceByGuid(guid string) (cfclient.UserProvidedServiceInstance, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	instance, ok := m.userProvided[guid]
+	if m.fail || !ok {
+		return instance, errors.New("No such user provided service instance")
+	}
+	return instance, nil
+}
+
+func (m *ServiceBindingClientMock) GetServicePlanByGUID(guid string) (*cfclient.ServicePlan, error) {
+	return &cfclient.ServicePlan{Guid: guid, Name: guid}, nil
+}
+
+func (m *ServiceBindingClientMock) GetServiceByGuid(guid string) (cfclient.Service, error) {
+	return cfclient.Service{Guid: guid, Label: guid}, nil
+}
+
+func (m *ServiceBindingClientMock) ListServiceBindingsCallCount() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.listBindingsCallCount
+}
+
+func (m *ServiceBindingClientMock) GetServiceInstanceCallCount() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.getInstanceCallCount
+}
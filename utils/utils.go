@@ -96,3 +96,19 @@ func ToJson(msg string) interface{} {
 	}
 	return msg
 }
+
+// UnmarshalJSONOrFile unmarshals value into v. If value doesn't look like JSON
+// it is treated as the path of a file containing the JSON, which allows
+// mounting configuration from a config map.
+func UnmarshalJSONOrFile(value string, v interface{}) error {
+	value = strings.TrimSpace(value)
+	data := []byte(value)
+	if !strings.HasPrefix(value, "[") && !strings.HasPrefix(value, "{") {
+		var err error
+		data, err = os.ReadFile(value)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %s", value, err)
+		}
+	}
+	return json.Unmarshal(data, v)
+}
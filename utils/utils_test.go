@@ -1,6 +1,8 @@
 package utils_test
 
 import (
+	"os"
+
 	. "github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
 	"github.com/cloudfoundry/sonde-go/events"
 	"github.com/gogo/protobuf/proto"
@@ -96,4 +98,28 @@ var _ = Describe("Testing Utils packages", func() {
 		nano := NanoSecondsToSeconds(1501981978112315664)
 		Expect(nano).To(Equal("1501981978.112315664"))
 	})
+
+	Describe("UnmarshalJSONOrFile", func() {
+		It("Should unmarshal JSON", func() {
+			var v []string
+			Expect(UnmarshalJSONOrFile(` ["a","b"]`, &v)).To(Succeed())
+			Expect(v).To(Equal([]string{"a", "b"}))
+		})
+
+		It("Should unmarshal JSON from file", func() {
+			file, _ := os.CreateTemp("", "utils")
+			defer os.Remove(file.Name())
+			file.WriteString(`{"a":"b"}`)
+			file.Close()
+
+			var v map[string]string
+			Expect(UnmarshalJSONOrFile(file.Name(), &v)).To(Succeed())
+			Expect(v).To(Equal(map[string]string{"a": "b"}))
+		})
+
+		It("Should return error on missing file", func() {
+			var v map[string]string
+			Expect(UnmarshalJSONOrFile("/not-exists.json", &v)).NotTo(Succeed())
+		})
+	})
 })